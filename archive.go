@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ArchiveFormatZip    = "zip"
+	ArchiveFormatTar    = "tar"
+	ArchiveFormatTarGz  = "tar.gz"
+	ArchiveFormatTarXz  = "tar.xz"
+	ArchiveFormatTarZst = "tar.zst"
+)
+
+var (
+	zipMagic  = [][]byte{[]byte("PK\x03\x04"), []byte("PK\x05\x06"), []byte("PK\x07\x08")}
+	gzipMagic = []byte{0x1f, 0x8b}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	// ustar magic lives at offset 257 of the first tar header block
+	tarMagic       = []byte("ustar")
+	tarMagicOffset = 257
+)
+
+// downloadAndExtractArchive downloads an archive from the first of urls that answers and extracts it into distPath
+func downloadAndExtractArchive(urls []string, checksum string, auth *downloadAuth, distPath string, options archiveOptions, filter fileFilter) {
+	// Create a temporary file to store the downloaded archive
+	tmpFile, err := os.CreateTemp("", "archive-*")
+	if err != nil {
+		panic("Failed to create temporary file: " + err.Error())
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	downloadFile(urls, checksum, auth, tmpFile)
+	extractArchive(tmpFile.Name(), distPath, options, filter)
+}
+
+// extractArchive extracts a zip or tar archive into distPath, the format is sniffed from the file content
+func extractArchive(archivePath string, distPath string, options archiveOptions, filter fileFilter) {
+	archiveFile, err := os.Open(archivePath)
+	if err != nil {
+		panic("Failed to open archive " + archivePath + ": " + err.Error())
+	}
+	format, err := detectArchiveFormat(archiveFile)
+	archiveFile.Close()
+	if err != nil {
+		panic("Failed to detect format of archive " + archivePath + ": " + err.Error())
+	}
+
+	slog.Info("Extracting " + format + " archive " + archivePath + " to " + distPath)
+	if format == ArchiveFormatZip {
+		extractZipArchive(archivePath, distPath, options, filter)
+	} else {
+		extractTarArchive(archivePath, format, distPath, options, filter)
+	}
+}
+
+// detectArchiveFormat reads the magic bytes at the start of r, compressed streams are assumed to contain a tar
+func detectArchiveFormat(r io.Reader) (string, error) {
+	header := make([]byte, tarMagicOffset+len(tarMagic))
+	n, err := io.ReadFull(r, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	header = header[:n]
+
+	for _, magic := range zipMagic {
+		if bytes.HasPrefix(header, magic) {
+			return ArchiveFormatZip, nil
+		}
+	}
+	if bytes.HasPrefix(header, gzipMagic) {
+		return ArchiveFormatTarGz, nil
+	}
+	if bytes.HasPrefix(header, xzMagic) {
+		return ArchiveFormatTarXz, nil
+	}
+	if bytes.HasPrefix(header, zstdMagic) {
+		return ArchiveFormatTarZst, nil
+	}
+	if len(header) >= tarMagicOffset+len(tarMagic) && bytes.Equal(header[tarMagicOffset:], tarMagic) {
+		return ArchiveFormatTar, nil
+	}
+	return "", errors.New("unrecognised archive format")
+}
+
+// cleanArchiveMemberName normalises an archive member name to a slash separated relative path, "." means the root
+func cleanArchiveMemberName(name string) string {
+	return path.Clean(strings.TrimLeft(name, "/"))
+}
+
+// ArchiveStripAuto strips a single top-level directory when every archive member lives inside it
+const ArchiveStripAuto = -1
+
+type archiveOptions struct {
+	// StripComponents is the number of leading path elements removed from member names, or ArchiveStripAuto
+	StripComponents int
+	// Subpath selects a directory inside the archive, only members beneath it are extracted. It is matched before
+	// StripComponents is applied, so it must include any wrapper directories
+	Subpath string
+}
+
+var archiveOptionsDefault = archiveOptions{
+	StripComponents: ArchiveStripAuto,
+}
+
+type archiveMember struct {
+	Name  string
+	IsDir bool
+}
+
+// commonTopLevelDir returns the first path element if every archive member lives inside that directory, a
+// single top-level file does not count as a directory
+func commonTopLevelDir(members []archiveMember) (string, bool) {
+	var topLevelDir string
+	foundTopLevelDir := false
+	for _, member := range members {
+		name := cleanArchiveMemberName(member.Name)
+		if name == "." {
+			continue
+		}
+		parts := strings.Split(name, "/")
+		if len(parts) == 1 && !member.IsDir {
+			return "", false
+		}
+
+		if !foundTopLevelDir {
+			topLevelDir = parts[0]
+			foundTopLevelDir = true
+		} else if parts[0] != topLevelDir {
+			return "", false
+		}
+	}
+	return topLevelDir, foundTopLevelDir
+}
+
+// stripComponents removes count leading path elements from an archive member name, "." means nothing is left
+func stripComponents(name string, count int) string {
+	parts := strings.Split(cleanArchiveMemberName(name), "/")
+	if len(parts) <= count {
+		return "."
+	}
+	return strings.Join(parts[count:], "/")
+}
+
+// selectSubpath returns the member name relative to subpath, or false if the member is not inside subpath
+func selectSubpath(name string, subpath string) (string, bool) {
+	name = cleanArchiveMemberName(name)
+	subpath = cleanArchiveMemberName(subpath)
+	if subpath == "." {
+		return name, true
+	}
+	if name == subpath {
+		return ".", true
+	}
+	return strings.CutPrefix(name, subpath+"/")
+}
+
+// newArchiveNameMapper resolves the archive options against the full member list and returns a function mapping
+// member names to destination names relative to the extraction root. Members mapped to "." are not extracted
+func newArchiveNameMapper(members []archiveMember, options archiveOptions) func(name string) string {
+	var selected []archiveMember
+	for _, member := range members {
+		if name, ok := selectSubpath(member.Name, options.Subpath); ok {
+			selected = append(selected, archiveMember{Name: name, IsDir: member.IsDir})
+		}
+	}
+	if options.Subpath != "" {
+		slog.Info(fmt.Sprintf("Selected %d of %d archive members beneath %s", len(selected), len(members), options.Subpath))
+		if len(selected) == 0 {
+			panic("Archive subpath " + options.Subpath + " does not exist in the archive")
+		}
+	}
+
+	strip := options.StripComponents
+	if strip == ArchiveStripAuto {
+		if _, ok := commonTopLevelDir(selected); ok {
+			slog.Warn("All files share a common top-level directory, extracting while omitting the top level directory")
+			strip = 1
+		} else {
+			slog.Info("Files have different top-level directories, extracting as normal")
+			strip = 0
+		}
+	} else {
+		slog.Info(fmt.Sprintf("Stripping %d leading path components from archive members", strip))
+	}
+
+	return func(name string) string {
+		name, ok := selectSubpath(name, options.Subpath)
+		if !ok {
+			return "."
+		}
+		return stripComponents(name, strip)
+	}
+}
+
+// resolveArchiveMemberPath joins an archive member name onto the extraction root, making sure the result can
+// neither escape the root lexically nor through a symlink that was extracted earlier
+func resolveArchiveMemberPath(root string, name string) (string, error) {
+	name = cleanArchiveMemberName(name)
+	if name == ".." || strings.HasPrefix(name, "../") {
+		return "", errors.New("archive member " + name + " escapes the extraction root")
+	}
+	memberPath := filepath.Join(root, filepath.FromSlash(name))
+
+	// Never write beneath a symlink, it could point anywhere once other members are extracted
+	parent := root
+	parts := strings.Split(name, "/")
+	for _, part := range parts[:len(parts)-1] {
+		parent = filepath.Join(parent, part)
+		info, err := os.Lstat(parent)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", errors.New("archive member " + name + " is located beneath symlink " + parent)
+		}
+	}
+	return memberPath, nil
+}
+
+// checkSymlinkTarget makes sure a symlink created at linkPath stays inside root
+func checkSymlinkTarget(root string, linkPath string, target string) error {
+	if filepath.IsAbs(target) {
+		return errors.New("symlink " + linkPath + " has absolute target " + target)
+	}
+	resolved := filepath.Join(filepath.Dir(linkPath), target)
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return errors.New("symlink " + linkPath + " points outside the extraction root: " + target)
+	}
+
+	// The lexical check above trusts every element of the target, so x/l/../.. looks harmless even when x/l is itself
+	// a link to "..". Parent references are only allowed at the start of the target and the target may not pass
+	// through a symlink, which keeps the lexical result equal to where the link really resolves to
+	parts := strings.Split(filepath.ToSlash(target), "/")
+	current := filepath.Dir(linkPath)
+	seenElement := false
+	for i, part := range parts {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if seenElement {
+				return errors.New("symlink " + linkPath + " has a parent reference after a path element: " + target)
+			}
+			current = filepath.Dir(current)
+			continue
+		}
+		seenElement = true
+		current = filepath.Join(current, part)
+		if i == len(parts)-1 {
+			continue
+		}
+		info, err := os.Lstat(current)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return errors.New("symlink " + linkPath + " points through symlink " + current + ": " + target)
+		}
+	}
+	return nil
+}
+
+// extractSymlink creates the symlink filePath pointing at target once the target passed checkSymlinkTarget, anything
+// already at filePath is replaced
+func extractSymlink(root string, filePath string, target string) {
+	if err := checkSymlinkTarget(root, filePath, target); err != nil {
+		panic("Refusing to extract archive member: " + err.Error())
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+		panic("Failed to create directory: " + err.Error())
+	}
+	if err := os.RemoveAll(filePath); err != nil {
+		panic("Failed to replace existing file: " + filePath + ", error: " + err.Error())
+	}
+	if err := os.Symlink(target, filePath); err != nil {
+		panic("Failed to create symlink: " + filePath + ", error: " + err.Error())
+	}
+}
+
+// removeExistingSymlink deletes a symlink at dst so that writing to dst never follows it
+func removeExistingSymlink(dst string) error {
+	info, err := os.Lstat(dst)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return os.Remove(dst)
+	}
+	return nil
+}
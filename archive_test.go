@@ -0,0 +1,354 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+type testArchiveMember struct {
+	Name     string
+	Content  string
+	Typeflag byte
+	Linkname string
+	Mode     int64
+}
+
+func writeTestTar(t *testing.T, format string, members []testArchiveMember) string {
+	archivePath := path.Join(t.TempDir(), "archive")
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var compressor io.WriteCloser
+	switch format {
+	case ArchiveFormatTar:
+		compressor = nopWriteCloser{file}
+	case ArchiveFormatTarGz:
+		compressor = gzip.NewWriter(file)
+	case ArchiveFormatTarXz:
+		compressor, err = xz.NewWriter(file)
+	case ArchiveFormatTarZst:
+		compressor, err = zstd.NewWriter(file)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tarWriter := tar.NewWriter(compressor)
+	for _, member := range members {
+		header := &tar.Header{
+			Name:     member.Name,
+			Typeflag: member.Typeflag,
+			Linkname: member.Linkname,
+			Mode:     member.Mode,
+			Size:     int64(len(member.Content)),
+		}
+		if header.Typeflag == 0 {
+			header.Typeflag = tar.TypeReg
+		}
+		if header.Mode == 0 {
+			header.Mode = 0644
+		}
+		if header.Typeflag != tar.TypeReg {
+			header.Size = 0
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tarWriter.Write([]byte(member.Content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := compressor.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return archivePath
+}
+
+func writeTestZip(t *testing.T, members []testArchiveMember) string {
+	archivePath := path.Join(t.TempDir(), "archive")
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	zipWriter := zip.NewWriter(file)
+	for _, member := range members {
+		header := &zip.FileHeader{Name: member.Name, Method: zip.Deflate}
+		content := member.Content
+		if member.Typeflag == tar.TypeSymlink {
+			header.SetMode(os.ModeSymlink | 0777)
+			content = member.Linkname
+		}
+		writer, err := zipWriter.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return archivePath
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func expectFileContent(t *testing.T, filePath string, content string) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("Expected %s to exist: %v", filePath, err)
+	}
+	if string(data) != content {
+		t.Fatalf("Expected %s to contain %q but got %q", filePath, content, string(data))
+	}
+}
+
+func expectPanic(t *testing.T, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Logf("Panic recovered: %v", r)
+		} else {
+			t.Fatal("Expected panic, but none occurred")
+		}
+	}()
+	fn()
+}
+
+func TestDetectArchiveFormat(t *testing.T) {
+	members := []testArchiveMember{{Name: "server.jar", Content: "jar"}}
+	for _, format := range []string{ArchiveFormatTar, ArchiveFormatTarGz, ArchiveFormatTarXz, ArchiveFormatTarZst} {
+		file, err := os.Open(writeTestTar(t, format, members))
+		if err != nil {
+			t.Fatal(err)
+		}
+		detected, err := detectArchiveFormat(file)
+		file.Close()
+		if err != nil || detected != format {
+			t.Fatalf("Expected format %s but got %s (%v)", format, detected, err)
+		}
+	}
+
+	file, err := os.Open(writeTestZip(t, members))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if detected, err := detectArchiveFormat(file); err != nil || detected != ArchiveFormatZip {
+		t.Fatalf("Expected format %s but got %s (%v)", ArchiveFormatZip, detected, err)
+	}
+
+	if _, err := detectArchiveFormat(bytes.NewReader([]byte("ducky"))); err == nil {
+		t.Fatal("Expected unknown content to be rejected")
+	}
+}
+
+func TestExtractTarArchivesWithSameTopLevelDir(t *testing.T) {
+	for _, format := range []string{ArchiveFormatTar, ArchiveFormatTarGz, ArchiveFormatTarXz, ArchiveFormatTarZst} {
+		archivePath := writeTestTar(t, format, []testArchiveMember{
+			{Name: "pack/", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "pack/mods/ducky.jar", Content: "quack"},
+			{Name: "pack/startserver.sh", Content: "java -jar server.jar", Mode: 0755},
+			{Name: "pack/start.sh", Typeflag: tar.TypeSymlink, Linkname: "startserver.sh"},
+			{Name: "pack/mods/goose.jar", Typeflag: tar.TypeLink, Linkname: "pack/mods/ducky.jar"},
+		})
+		serverDir := t.TempDir()
+
+		extractArchive(archivePath, serverDir, archiveOptionsDefault, fileFilter{})
+
+		expectFileContent(t, path.Join(serverDir, "mods", "ducky.jar"), "quack")
+		expectFileContent(t, path.Join(serverDir, "mods", "goose.jar"), "quack")
+		expectFileContent(t, path.Join(serverDir, "start.sh"), "java -jar server.jar")
+		info, err := os.Stat(path.Join(serverDir, "startserver.sh"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0755 {
+			t.Fatalf("Expected startserver.sh to keep mode 0755 but got %v", info.Mode().Perm())
+		}
+	}
+}
+
+func TestExtractTarArchiveWithDifferentTopLevelDirs(t *testing.T) {
+	archivePath := writeTestTar(t, ArchiveFormatTarGz, []testArchiveMember{
+		{Name: "./mods/ducky.jar", Content: "quack"},
+		{Name: "./server.properties", Content: "motd=ducky"},
+	})
+	serverDir := t.TempDir()
+
+	extractArchive(archivePath, serverDir, archiveOptionsDefault, fileFilter{})
+
+	expectFileContent(t, path.Join(serverDir, "mods", "ducky.jar"), "quack")
+	expectFileContent(t, path.Join(serverDir, "server.properties"), "motd=ducky")
+}
+
+func TestExtractTarArchiveWithEscapingSymlink(t *testing.T) {
+	archivePath := writeTestTar(t, ArchiveFormatTar, []testArchiveMember{
+		{Name: "mods", Typeflag: tar.TypeSymlink, Linkname: "../../"},
+		{Name: "config/ducky.toml", Content: "quack"},
+	})
+	expectPanic(t, func() { extractArchive(archivePath, t.TempDir(), archiveOptionsDefault, fileFilter{}) })
+}
+
+func TestExtractTarArchiveThroughSymlink(t *testing.T) {
+	archivePath := writeTestTar(t, ArchiveFormatTar, []testArchiveMember{
+		{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "."},
+		{Name: "a/b", Typeflag: tar.TypeSymlink, Linkname: "../ducky"},
+	})
+	expectPanic(t, func() { extractArchive(archivePath, t.TempDir(), archiveOptionsDefault, fileFilter{}) })
+}
+
+func TestExtractTarArchiveWithChainedSymlinks(t *testing.T) {
+	for _, members := range [][]testArchiveMember{
+		{
+			{Name: "x/l", Typeflag: tar.TypeSymlink, Linkname: ".."},
+			{Name: "m", Typeflag: tar.TypeSymlink, Linkname: "x/l/../.."},
+		},
+		{
+			{Name: "m", Typeflag: tar.TypeSymlink, Linkname: "x/l/../.."},
+			{Name: "x/l", Typeflag: tar.TypeSymlink, Linkname: ".."},
+		},
+		{
+			{Name: "x/l", Typeflag: tar.TypeSymlink, Linkname: ".."},
+			{Name: "m", Typeflag: tar.TypeSymlink, Linkname: "x/l/ducky"},
+		},
+	} {
+		archivePath := writeTestTar(t, ArchiveFormatTar, members)
+		expectPanic(t, func() { extractArchive(archivePath, t.TempDir(), archiveOptionsDefault, fileFilter{}) })
+	}
+}
+
+func TestExtractTarArchiveWithParentSymlink(t *testing.T) {
+	archivePath := writeTestTar(t, ArchiveFormatTar, []testArchiveMember{
+		{Name: "config/ducky.toml", Content: "quack"},
+		{Name: "mods/config", Typeflag: tar.TypeSymlink, Linkname: "../config"},
+	})
+	serverDir := t.TempDir()
+
+	extractArchive(archivePath, serverDir, archiveOptions{}, fileFilter{})
+
+	expectFileContent(t, path.Join(serverDir, "mods", "config", "ducky.toml"), "quack")
+}
+
+func TestExtractZipArchiveWithSymlink(t *testing.T) {
+	archivePath := writeTestZip(t, []testArchiveMember{
+		{Name: "startserver.sh", Content: "java -jar server.jar"},
+		{Name: "start.sh", Typeflag: tar.TypeSymlink, Linkname: "startserver.sh"},
+	})
+	serverDir := t.TempDir()
+
+	extractArchive(archivePath, serverDir, archiveOptions{}, fileFilter{})
+
+	info, err := os.Lstat(path.Join(serverDir, "start.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("Expected start.sh to be a symlink but got mode %v", info.Mode())
+	}
+	expectFileContent(t, path.Join(serverDir, "start.sh"), "java -jar server.jar")
+}
+
+func TestExtractZipArchiveWithEscapingSymlink(t *testing.T) {
+	archivePath := writeTestZip(t, []testArchiveMember{
+		{Name: "mods/ducky.jar", Content: "quack"},
+		{Name: "mods/up", Typeflag: tar.TypeSymlink, Linkname: "../../.."},
+	})
+	expectPanic(t, func() { extractArchive(archivePath, t.TempDir(), archiveOptions{}, fileFilter{}) })
+}
+
+func TestExtractTarArchiveWithEscapingHardLink(t *testing.T) {
+	archivePath := writeTestTar(t, ArchiveFormatTar, []testArchiveMember{
+		{Name: "mods/ducky.jar", Content: "quack"},
+		{Name: "passwd", Typeflag: tar.TypeLink, Linkname: "../../etc/passwd"},
+	})
+	expectPanic(t, func() { extractArchive(archivePath, t.TempDir(), archiveOptionsDefault, fileFilter{}) })
+}
+
+func TestExtractZipArchiveWithEscapingMember(t *testing.T) {
+	archivePath := writeTestZip(t, []testArchiveMember{
+		{Name: "mods/ducky.jar", Content: "quack"},
+		{Name: "../ducky.txt", Content: "quack"},
+	})
+	serverDir := path.Join(t.TempDir(), "server")
+	expectPanic(t, func() { extractArchive(archivePath, serverDir, archiveOptionsDefault, fileFilter{}) })
+	if _, err := os.Stat(path.Join(serverDir, "..", "ducky.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("Expected ducky.txt to not be written outside of the server directory")
+	}
+}
+
+func TestExtractArchiveWithSingleTopLevelFile(t *testing.T) {
+	archivePath := writeTestZip(t, []testArchiveMember{
+		{Name: "server.jar", Content: "jar"},
+	})
+	serverDir := t.TempDir()
+
+	extractArchive(archivePath, serverDir, archiveOptionsDefault, fileFilter{})
+
+	expectFileContent(t, path.Join(serverDir, "server.jar"), "jar")
+}
+
+func TestExtractArchiveWithStripComponents(t *testing.T) {
+	members := []testArchiveMember{
+		{Name: "Pack/server/mods/ducky.jar", Content: "quack"},
+		{Name: "Pack/server/startserver.sh", Content: "java -jar server.jar"},
+	}
+	options := archiveOptions{StripComponents: 2}
+	for _, archivePath := range []string{writeTestZip(t, members), writeTestTar(t, ArchiveFormatTarGz, members)} {
+		serverDir := t.TempDir()
+
+		extractArchive(archivePath, serverDir, options, fileFilter{})
+
+		expectFileContent(t, path.Join(serverDir, "mods", "ducky.jar"), "quack")
+		expectFileContent(t, path.Join(serverDir, "startserver.sh"), "java -jar server.jar")
+	}
+}
+
+func TestExtractArchiveWithSubpath(t *testing.T) {
+	members := []testArchiveMember{
+		{Name: "Pack/client-files/mods/shaders.jar", Content: "shiny"},
+		{Name: "Pack/server-files/server/mods/ducky.jar", Content: "quack"},
+		{Name: "Pack/server-files/server/startserver.sh", Content: "java -jar server.jar"},
+	}
+	options := archiveOptions{StripComponents: ArchiveStripAuto, Subpath: "Pack/server-files"}
+	for _, archivePath := range []string{writeTestZip(t, members), writeTestTar(t, ArchiveFormatTar, members)} {
+		serverDir := t.TempDir()
+
+		extractArchive(archivePath, serverDir, options, fileFilter{})
+
+		expectFileContent(t, path.Join(serverDir, "mods", "ducky.jar"), "quack")
+		expectFileContent(t, path.Join(serverDir, "startserver.sh"), "java -jar server.jar")
+		if _, err := os.Stat(path.Join(serverDir, "mods", "shaders.jar")); !errors.Is(err, os.ErrNotExist) {
+			t.Fatal("Expected client files to not be extracted")
+		}
+	}
+}
+
+func TestExtractArchiveWithMissingSubpath(t *testing.T) {
+	archivePath := writeTestZip(t, []testArchiveMember{{Name: "mods/ducky.jar", Content: "quack"}})
+	expectPanic(t, func() {
+		extractArchive(archivePath, t.TempDir(), archiveOptions{StripComponents: ArchiveStripAuto, Subpath: "server-files"}, fileFilter{})
+	})
+}
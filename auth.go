@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// downloadAuth authenticates the downloads and clones of a source, including its mirrors. HeaderValue and Password
+// are secrets, so they can be read from files with HeaderValueFile and PasswordFile
+type downloadAuth struct {
+	// Header is sent with HeaderValue on every request, like Authorization with "Bearer token" or PRIVATE-TOKEN
+	Header      string
+	HeaderValue string
+	// Username and Password are sent as basic auth
+	Username string
+	Password string
+	// ClientCertPath and ClientKeyPath are PEM files presented for mutual TLS
+	ClientCertPath string
+	ClientKeyPath  string
+
+	certificate *tls.Certificate
+}
+
+// validateDownloadAuth checks the auth of a source and loads its client certificate, name maps its fields to the
+// reported paths
+func validateDownloadAuth(name fieldNamer, auth *downloadAuth, problems *validationErrors) *downloadAuth {
+	if auth == nil {
+		return nil
+	}
+	if (auth.Header == "") != (auth.HeaderValue == "") {
+		problems.add(name("Header"), "needs both Header and HeaderValue")
+	}
+	if auth.Header != "" && strings.ContainsAny(auth.Header, " :\t\r\n") {
+		problems.add(name("Header"), "invalid header name "+strconv.Quote(auth.Header))
+	}
+	if strings.ContainsAny(auth.HeaderValue, "\r\n") {
+		problems.add(name("HeaderValue"), "must be a single line")
+	}
+	if auth.Password != "" && auth.Username == "" {
+		problems.add(name("Username"), "a password needs a username")
+	}
+	if auth.Username != "" && http.CanonicalHeaderKey(auth.Header) == "Authorization" {
+		problems.add(name("Header"), "set either an Authorization header or Username and Password")
+	}
+
+	if (auth.ClientCertPath == "") != (auth.ClientKeyPath == "") {
+		problems.add(name("ClientCertPath"), "needs both ClientCertPath and ClientKeyPath")
+	} else if auth.ClientCertPath != "" {
+		certificate, err := tls.LoadX509KeyPair(auth.ClientCertPath, auth.ClientKeyPath)
+		if err != nil {
+			problems.add(name("ClientCertPath"), "failed to load client certificate: "+err.Error())
+		} else {
+			auth.certificate = &certificate
+		}
+	}
+	return auth
+}
+
+// SetAuth adds the header and basic auth to a request, it makes downloadAuth usable as auth method for git clones
+func (auth *downloadAuth) SetAuth(r *http.Request) {
+	if auth.Header != "" {
+		r.Header.Set(auth.Header, auth.HeaderValue)
+	}
+	if auth.Username != "" {
+		r.SetBasicAuth(auth.Username, auth.Password)
+	}
+}
+
+// checkRedirect is the CheckRedirect of the clients sending auth. The credentials are meant for the host of the
+// source, so they are removed when a redirect leads to another host, like a presigned url of an object store
+func (auth *downloadAuth) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if auth == nil || req.URL.Host == via[0].URL.Host {
+		return nil
+	}
+	if auth.Header != "" {
+		req.Header.Del(auth.Header)
+	}
+	if auth.Username != "" {
+		req.Header.Del("Authorization")
+	}
+	return nil
+}
+
+func (auth *downloadAuth) Name() string {
+	return "omsms-download-auth"
+}
+
+// String describes the auth without its secrets
+func (auth *downloadAuth) String() string {
+	var methods []string
+	if auth.Header != "" {
+		methods = append(methods, "header "+auth.Header)
+	}
+	if auth.Username != "" {
+		methods = append(methods, "basic auth as "+auth.Username)
+	}
+	if auth.certificate != nil {
+		methods = append(methods, "client certificate "+auth.ClientCertPath)
+	}
+	if len(methods) == 0 {
+		return "no auth"
+	}
+	return strings.Join(methods, ", ")
+}
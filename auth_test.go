@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestClientCertificate writes a self signed client certificate and its key as PEM files
+func writeTestClientCertificate(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ducky"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privateKey}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+func TestDownloadWithAuth(t *testing.T) {
+	setFastRetries(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ := r.BasicAuth()
+		if r.Header.Get("PRIVATE-TOKEN") != "quack" || username != "ducky" || password != "honk" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, "ducky")
+	}))
+	defer server.Close()
+
+	auth := &downloadAuth{Header: "PRIVATE-TOKEN", HeaderValue: "quack", Username: "ducky", Password: "honk"}
+	if content := string(downloadBytesWithAuth(t, server.URL+"/pack.zip", auth)); content != "ducky" {
+		t.Fatalf("Expected the ducky content but got %q", content)
+	}
+	expectPanic(t, func() {
+		downloadBytesWithAuth(t, server.URL+"/pack.zip", nil)
+	})
+	if strings.Contains(auth.String(), "quack") || strings.Contains(auth.String(), "honk") {
+		t.Fatalf("Expected the auth description to leave out secrets but got %s", auth.String())
+	}
+}
+
+func TestDownloadRedirectDropsAuth(t *testing.T) {
+	setFastRetries(t)
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("PRIVATE-TOKEN") != "" || r.Header.Get("Authorization") != "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, "ducky")
+	}))
+	defer mirror.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/moved.zip" {
+			http.Redirect(w, r, "/pack.zip", http.StatusFound)
+			return
+		}
+		if r.Header.Get("PRIVATE-TOKEN") != "quack" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.Redirect(w, r, mirror.URL+"/pack.zip", http.StatusFound)
+	}))
+	defer server.Close()
+
+	// The auth is kept on the same host and dropped for the other one
+	auth := &downloadAuth{Header: "PRIVATE-TOKEN", HeaderValue: "quack", Username: "ducky", Password: "honk"}
+	if content := string(downloadBytesWithAuth(t, server.URL+"/moved.zip", auth)); content != "ducky" {
+		t.Fatalf("Expected the ducky content but got %q", content)
+	}
+}
+
+func TestDownloadWithClientCertificate(t *testing.T) {
+	setFastRetries(t)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ducky")
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+	trustTestServer(t, server)
+
+	certPath, keyPath := writeTestClientCertificate(t)
+	var problems validationErrors
+	auth := validateDownloadAuth(fieldPrefix("Auth"), &downloadAuth{ClientCertPath: certPath, ClientKeyPath: keyPath}, &problems)
+	if err := problems.err(); err != nil {
+		t.Fatal(err)
+	}
+	if content := string(downloadBytesWithAuth(t, server.URL+"/pack.zip", auth)); content != "ducky" {
+		t.Fatalf("Expected the ducky content but got %q", content)
+	}
+	expectPanic(t, func() {
+		downloadBytesWithAuth(t, server.URL+"/pack.zip", nil)
+	})
+}
+
+// trustTestServer makes downloads verify against the certificate of server for the rest of the test
+func trustTestServer(t *testing.T, server *httptest.Server) {
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	DownloadRootCAs = pool
+	t.Cleanup(func() { DownloadRootCAs = nil })
+}
+
+func TestDownloadVerifiesServerCertificate(t *testing.T) {
+	setFastRetries(t)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ducky")
+	}))
+	defer server.Close()
+
+	expectPanic(t, func() {
+		downloadBytesWithAuth(t, server.URL+"/pack.zip", nil)
+	})
+	trustTestServer(t, server)
+	if content := string(downloadBytesWithAuth(t, server.URL+"/pack.zip", nil)); content != "ducky" {
+		t.Fatalf("Expected the ducky content but got %q", content)
+	}
+}
+
+func downloadBytesWithAuth(t *testing.T, url string, auth *downloadAuth) []byte {
+	t.Helper()
+	dst, err := os.CreateTemp(t.TempDir(), "download-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	downloadFile([]string{url}, "", auth, dst)
+	content, err := os.ReadFile(dst.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestValidateDownloadAuth(t *testing.T) {
+	certPath, _ := writeTestClientCertificate(t)
+	for field, auth := range map[string]*downloadAuth{
+		"Header":         {Header: "PRIVATE-TOKEN"},
+		"HeaderValue":    {Header: "Authorization", HeaderValue: "Bearer quack\nX-Goose: honk"},
+		"Username":       {Password: "honk"},
+		"ClientCertPath": {ClientCertPath: certPath},
+	} {
+		expectInvalid(t, validateDownloadAuth, auth, field)
+	}
+	expectInvalid(t, validateDownloadAuth, &downloadAuth{Header: "Authorization", HeaderValue: "Bearer quack", Username: "ducky"}, "Header")
+	expectInvalid(t, validateDownloadAuth, &downloadAuth{ClientCertPath: certPath, ClientKeyPath: certPath}, "ClientCertPath")
+}
+
+func TestReadEnvsWithAuthFromSecretFile(t *testing.T) {
+	tokenFile := writeTestConfigFile(t, "token", "Bearer quack-1234\n")
+	t.Setenv("OMSMS_SERVER_FILES_INIT", "{}")
+	t.Setenv("OMSMS_SERVER_DEPLOYMENT_TYPE", DeploymentTypeZip)
+	t.Setenv("OMSMS_SERVER_DEPLOYMENT_VALUE", "https://artifacts.ducky.com/pack.zip")
+	t.Setenv("OMSMS_SERVER_DEPLOYMENT_AUTH", `{"Header": "Authorization", "HeaderValueFile": "`+tokenFile+`"}`)
+
+	envs, err := readEnvs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth := envs.sources[0].Auth; auth == nil || auth.HeaderValue != "Bearer quack-1234" {
+		t.Fatalf("Expected the header value from the secret file but got %+v", auth)
+	}
+	if redacted := redactString("Sending Bearer quack-1234"); strings.Contains(redacted, "quack-1234") {
+		t.Fatalf("Expected the header value to be redacted but got %s", redacted)
+	}
+
+	t.Setenv("OMSMS_SERVER_DEPLOYMENT_AUTH", `{"Header": "Authorization"}`)
+	_, err = readEnvs()
+	if err == nil || !strings.Contains(err.Error(), "OMSMS_SERVER_DEPLOYMENT_AUTH.Header") {
+		t.Fatalf("Expected a problem with OMSMS_SERVER_DEPLOYMENT_AUTH.Header but got %v", err)
+	}
+}
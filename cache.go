@@ -0,0 +1,335 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// CacheDir is set by the -cache-dir flag, OMSMS_CACHE_DIR is used when it is empty. Without a cache directory every
+// download goes to the network
+var CacheDir = ""
+
+const DefaultCacheMaxSizeMb = 10240
+
+func init() {
+	flag.StringVar(&CacheDir, "cache-dir", "", "Directory downloads are cached in, can be shared by several init containers, overrides OMSMS_CACHE_DIR")
+}
+
+func getCacheDir(problems *validationErrors) string {
+	if CacheDir != "" {
+		return CacheDir
+	}
+	return getEnv("OMSMS_CACHE_DIR", problems)
+}
+
+type cacheConfig struct {
+	Dir       string
+	MaxSizeMb int64
+}
+
+// downloadCache is the cache used by downloadFile, nil when caching is disabled
+var downloadCache *fileCache
+
+// useDownloadCache makes downloadFile go through the cache configured in config
+func useDownloadCache(config cacheConfig) {
+	downloadCache = nil
+	if config.Dir == "" {
+		return
+	}
+	downloadCache = &fileCache{dir: config.Dir, maxSize: config.MaxSizeMb * 1024 * 1024}
+	slog.Info(fmt.Sprintf("Caching downloads in %s, up to %dM", config.Dir, config.MaxSizeMb))
+}
+
+// fileCache stores downloads under the sha256 of their content in objects/, index.json maps the urls to them with
+// the validators needed for conditional requests. Several processes can share the directory, every change to it
+// happens while holding an exclusive lock on the lock file
+type fileCache struct {
+	dir     string
+	maxSize int64
+}
+
+// cacheIndex is keyed by the sha256 of the url, so tokens in urls are not written to a shared volume
+type cacheIndex struct {
+	Entries map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	// Url is redacted and only kept for people looking at the index
+	Url          string
+	Hash         string
+	Size         int64
+	ETag         string
+	LastModified string
+	LastUsed     time.Time
+}
+
+func cacheKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *fileCache) objectPath(hash string) string {
+	return filepath.Join(c.dir, "objects", hash)
+}
+
+// lock takes the exclusive lock on the cache directory and returns the function releasing it
+func (c *fileCache) lock() (func(), error) {
+	if err := os.MkdirAll(filepath.Join(c.dir, "objects"), 0755); err != nil {
+		return nil, err
+	}
+	lockFile, err := os.OpenFile(filepath.Join(c.dir, "lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		lockFile.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+		lockFile.Close()
+	}, nil
+}
+
+func (c *fileCache) readIndex() (cacheIndex, error) {
+	index := cacheIndex{Entries: map[string]*cacheEntry{}}
+	data, err := os.ReadFile(filepath.Join(c.dir, "index.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return index, nil
+	}
+	if err != nil {
+		return index, err
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		slog.Warn("Ignoring corrupt download cache index: " + err.Error())
+		return cacheIndex{Entries: map[string]*cacheEntry{}}, nil
+	}
+	if index.Entries == nil {
+		index.Entries = map[string]*cacheEntry{}
+	}
+	return index, nil
+}
+
+// writeIndex replaces the index atomically, so a crash never leaves a partial index behind
+func (c *fileCache) writeIndex(index cacheIndex) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := filepath.Join(c.dir, "index.json.tmp")
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(c.dir, "index.json"))
+}
+
+// lookup returns the entry for url if its object is still in the cache
+func (c *fileCache) lookup(url string) (*cacheEntry, error) {
+	unlock, err := c.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	index, err := c.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	entry := index.Entries[cacheKey(url)]
+	if entry == nil {
+		return nil, nil
+	}
+	if _, err := os.Stat(c.objectPath(entry.Hash)); err != nil {
+		return nil, nil
+	}
+	return entry, nil
+}
+
+// fetch writes the content at url to dst, from the cache when the server confirms the cached copy is current.
+// A download that fails after every retry with a network error or a server error falls back to the cached copy,
+// a client error like 403 or 404 means the copy is no longer meant to be served. A download is only stored when it
+// matches checksum, if there is one
+func (c *fileCache) fetch(url string, checksum string, auth *downloadAuth, dst *os.File) error {
+	cached, err := c.lookup(url)
+	if err != nil {
+		return errors.New("failed to read download cache: " + err.Error())
+	}
+
+	header := http.Header{}
+	if cached != nil {
+		if cached.ETag != "" {
+			header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	// Download into the cache directory, so moving the object into place is a rename
+	tmpFile, err := os.CreateTemp(c.dir, "download-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+	// The shared lock marks the download as in progress for sweepDownloads in other processes, it is released when
+	// this process dies
+	if err := syscall.Flock(int(tmpFile.Fd()), syscall.LOCK_SH); err != nil {
+		return err
+	}
+	resp, err := fetchURL(url, auth, header, tmpFile)
+	var retryable retryableError
+	switch {
+	case err != nil && cached != nil && errors.As(err, &retryable):
+		slog.Warn("Failed to download " + url + ", using the cached copy: " + err.Error())
+		return c.use(url, nil, "", dst)
+	case err != nil:
+		return err
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		slog.Info("Using cached copy of " + url)
+		return c.use(url, nil, "", dst)
+	case resp.StatusCode == http.StatusNotModified:
+		return errors.New("unexpected status " + resp.Status + " without a cached copy")
+	}
+
+	if checksum != "" {
+		if err := verifyFileHash(tmpFile.Name(), checksum); err != nil {
+			return err
+		}
+	}
+	if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, tmpFile)
+	if err != nil {
+		return err
+	}
+	entry := &cacheEntry{
+		Url:          redactString(url),
+		Hash:         hex.EncodeToString(hasher.Sum(nil)),
+		Size:         size,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	return c.use(url, entry, tmpFile.Name(), dst)
+}
+
+// use copies the cached copy of url to dst and marks it as used. A new entry is stored first, with its object moved
+// in from downloadPath, then the cache is trimmed to its maximum size
+func (c *fileCache) use(url string, entry *cacheEntry, downloadPath string, dst *os.File) error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	index, err := c.readIndex()
+	if err != nil {
+		return err
+	}
+
+	key := cacheKey(url)
+	if entry != nil {
+		if _, err := os.Stat(c.objectPath(entry.Hash)); errors.Is(err, os.ErrNotExist) {
+			if err := os.Rename(downloadPath, c.objectPath(entry.Hash)); err != nil {
+				return err
+			}
+		}
+		index.Entries[key] = entry
+	}
+	entry = index.Entries[key]
+	if entry == nil {
+		return errors.New("cached copy of " + url + " was evicted")
+	}
+	entry.LastUsed = time.Now()
+
+	object, err := os.Open(c.objectPath(entry.Hash))
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+	if _, err := io.Copy(dst, object); err != nil {
+		return err
+	}
+
+	c.evict(&index, entry.Hash)
+	c.sweepDownloads()
+	return c.writeIndex(index)
+}
+
+// sweepDownloads removes the downloads left behind by processes that were killed before cleaning up. A download
+// still in progress holds a lock on its file, so only files nobody holds a lock on are removed
+func (c *fileCache) sweepDownloads() {
+	downloads, err := filepath.Glob(filepath.Join(c.dir, "download-*"))
+	if err != nil {
+		slog.Warn("Failed to list downloads in the download cache: " + err.Error())
+		return
+	}
+	for _, downloadPath := range downloads {
+		download, err := os.Open(downloadPath)
+		if err != nil {
+			continue
+		}
+		if err := syscall.Flock(int(download.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err == nil {
+			if err := os.Remove(downloadPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+				slog.Warn("Failed to remove stale download " + downloadPath + ": " + err.Error())
+			} else {
+				slog.Info("Removed stale download " + downloadPath + " from the download cache")
+			}
+		}
+		download.Close()
+	}
+}
+
+// evict removes the least recently used objects until the cache fits its maximum size, keep is never removed.
+// Objects shared by several urls are removed with all of their entries
+func (c *fileCache) evict(index *cacheIndex, keep string) {
+	lastUsed := map[string]time.Time{}
+	sizes := map[string]int64{}
+	for _, entry := range index.Entries {
+		if entry.LastUsed.After(lastUsed[entry.Hash]) {
+			lastUsed[entry.Hash] = entry.LastUsed
+		}
+		sizes[entry.Hash] = entry.Size
+	}
+	var total int64
+	hashes := make([]string, 0, len(sizes))
+	for hash, size := range sizes {
+		total += size
+		hashes = append(hashes, hash)
+	}
+	slices.SortFunc(hashes, func(a string, b string) int {
+		return lastUsed[a].Compare(lastUsed[b])
+	})
+
+	for _, hash := range hashes {
+		if total <= c.maxSize {
+			break
+		}
+		if hash == keep {
+			continue
+		}
+		if err := os.Remove(c.objectPath(hash)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("Failed to evict " + hash + " from the download cache: " + err.Error())
+			continue
+		}
+		for key, entry := range index.Entries {
+			if entry.Hash == hash {
+				delete(index.Entries, key)
+			}
+		}
+		total -= sizes[hash]
+		slog.Info(fmt.Sprintf("Evicted %s (%d bytes) from the download cache", hash, sizes[hash]))
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+)
+
+// newTestCacheServer serves path-named content with an ETag and counts full and conditional responses
+func newTestCacheServer(t *testing.T, full *atomic.Int32, notModified *atomic.Int32) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := `"` + strings.Trim(r.URL.Path, "/") + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full.Add(1)
+		w.Header().Set("ETag", etag)
+		fmt.Fprint(w, strings.Repeat(strings.Trim(r.URL.Path, "/"), 100))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func fetchCachedFile(cache *fileCache, url string, dir string) (string, error) {
+	dst, err := os.CreateTemp(dir, "download-*")
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+	if err := cache.fetch(url, "", nil, dst); err != nil {
+		return "", err
+	}
+	content, err := os.ReadFile(dst.Name())
+	return string(content), err
+}
+
+func fetchTestFile(t *testing.T, cache *fileCache, url string) string {
+	t.Helper()
+	content, err := fetchCachedFile(cache, url, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestDownloadCacheRevalidates(t *testing.T) {
+	setFastRetries(t)
+	var full, notModified atomic.Int32
+	server := newTestCacheServer(t, &full, &notModified)
+	cache := &fileCache{dir: t.TempDir(), maxSize: 1024 * 1024}
+
+	for range 3 {
+		if content := fetchTestFile(t, cache, server.URL+"/ducky"); content != strings.Repeat("ducky", 100) {
+			t.Fatalf("Expected the ducky content but got %q", content)
+		}
+	}
+	if full.Load() != 1 || notModified.Load() != 2 {
+		t.Fatalf("Expected 1 full and 2 conditional responses but got %d and %d", full.Load(), notModified.Load())
+	}
+
+	index, err := cache.readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := index.Entries[cacheKey(server.URL+"/ducky")]
+	if entry == nil || entry.ETag != `"ducky"` || entry.Size != 500 {
+		t.Fatalf("Expected an index entry with the ETag but got %+v", entry)
+	}
+
+	// A server that went away is replaced by the cached copy
+	server.Close()
+	if content := fetchTestFile(t, cache, server.URL+"/ducky"); content != strings.Repeat("ducky", 100) {
+		t.Fatalf("Expected the cached ducky content but got %q", content)
+	}
+}
+
+func TestDownloadCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	var full, notModified atomic.Int32
+	server := newTestCacheServer(t, &full, &notModified)
+	// Room for two of the 500 byte downloads
+	cache := &fileCache{dir: t.TempDir(), maxSize: 1200}
+
+	fetchTestFile(t, cache, server.URL+"/ducky")
+	fetchTestFile(t, cache, server.URL+"/goose")
+	fetchTestFile(t, cache, server.URL+"/ducky")
+	fetchTestFile(t, cache, server.URL+"/swans")
+
+	index, err := cache.readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := index.Entries[cacheKey(server.URL+"/goose")]; ok || len(index.Entries) != 2 {
+		t.Fatalf("Expected goose to be evicted but got %d entries", len(index.Entries))
+	}
+	objects, err := os.ReadDir(path.Join(cache.dir, "objects"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 {
+		t.Fatalf("Expected 2 cached objects but got %d", len(objects))
+	}
+}
+
+func TestDownloadCacheIsSharedSafely(t *testing.T) {
+	var full, notModified atomic.Int32
+	server := newTestCacheServer(t, &full, &notModified)
+	dir := t.TempDir()
+	downloadDir := t.TempDir()
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Every fetch uses its own cache like separate init containers would
+			cache := &fileCache{dir: dir, maxSize: 1024 * 1024}
+			name := []string{"ducky", "goose"}[i%2]
+			content, err := fetchCachedFile(cache, server.URL+"/"+name, downloadDir)
+			if err != nil {
+				t.Error(err)
+			} else if content != strings.Repeat(name, 100) {
+				t.Errorf("Expected the %s content but got %q", name, content)
+			}
+		}()
+	}
+	wg.Wait()
+
+	index, err := (&fileCache{dir: dir}).readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Entries) != 2 {
+		t.Fatalf("Expected 2 index entries but got %d", len(index.Entries))
+	}
+}
+
+func TestDownloadCacheDoesNotServeRemovedFiles(t *testing.T) {
+	var removed atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if removed.Load() {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", `"ducky"`)
+		fmt.Fprint(w, "ducky")
+	}))
+	defer server.Close()
+	cache := &fileCache{dir: t.TempDir(), maxSize: 1024 * 1024}
+
+	fetchTestFile(t, cache, server.URL+"/ducky")
+	removed.Store(true)
+	if content, err := fetchCachedFile(cache, server.URL+"/ducky", t.TempDir()); err == nil {
+		t.Fatalf("Expected the 404 to fail the download instead of serving the cached %q", content)
+	}
+}
+
+func TestDownloadCacheSweepsStaleDownloads(t *testing.T) {
+	var full, notModified atomic.Int32
+	server := newTestCacheServer(t, &full, &notModified)
+	cache := &fileCache{dir: t.TempDir(), maxSize: 1024 * 1024}
+	stalePath := path.Join(cache.dir, "download-stale")
+	if err := os.WriteFile(stalePath, []byte("duck"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A download of another process that is still running keeps its lock
+	running, err := os.Create(path.Join(cache.dir, "download-running"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer running.Close()
+	if err := syscall.Flock(int(running.Fd()), syscall.LOCK_SH); err != nil {
+		t.Fatal(err)
+	}
+
+	fetchTestFile(t, cache, server.URL+"/ducky")
+
+	if _, err := os.Stat(stalePath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected the stale download to be removed but got %v", err)
+	}
+	if _, err := os.Stat(running.Name()); err != nil {
+		t.Fatalf("Expected the running download to be kept but got %v", err)
+	}
+}
+
+func TestCacheDirFromSecretFile(t *testing.T) {
+	cacheDir := t.TempDir()
+	t.Setenv("OMSMS_CACHE_DIR_FILE", writeTestConfigFile(t, "cache-dir", cacheDir+"\n"))
+	var problems validationErrors
+	if dir := getCacheDir(&problems); dir != cacheDir || len(problems) != 0 {
+		t.Fatalf("Expected the cache directory %s from the secret file but got %s (%v)", cacheDir, dir, problems)
+	}
+}
+
+func TestDownloadCacheOnlyStoresVerifiedDownloads(t *testing.T) {
+	var full, notModified atomic.Int32
+	server := newTestCacheServer(t, &full, &notModified)
+	cache := &fileCache{dir: t.TempDir(), maxSize: 1024 * 1024}
+
+	dst, err := os.CreateTemp(t.TempDir(), "download-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if err := cache.fetch(server.URL+"/ducky", "sha256:"+strings.Repeat("0", 64), nil, dst); err == nil {
+		t.Fatal("Expected the checksum mismatch to fail the download")
+	}
+
+	if entry, err := cache.lookup(server.URL + "/ducky"); err != nil || entry != nil {
+		t.Fatalf("Expected the download to be kept out of the cache but got %+v (%v)", entry, err)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	CommandInit          = "init"
+	CommandValidate      = "validate"
+	CommandPlan          = "plan"
+	CommandPrintDefaults = "print-defaults"
+	CommandSchema        = "schema"
+)
+
+var Commands = []string{CommandInit, CommandValidate, CommandPlan, CommandPrintDefaults, CommandSchema}
+
+var (
+	// DryRun makes init record its changes to the server folder and print them instead of applying them
+	DryRun       = false
+	DryRunFormat = DryRunFormatText
+)
+
+func init() {
+	flag.BoolVar(&DryRun, "dry-run", false, "Print the changes init would make to the server folder without applying them")
+	flag.StringVar(&DryRunFormat, "dry-run-format", DryRunFormatText, "Format of the dry run report, one of "+strings.Join(DryRunFormats, ", "))
+	flag.Usage = func() {
+		output := flag.CommandLine.Output()
+		fmt.Fprintf(output, `Usage: %s [flags] [command]
+
+Commands:
+  init            Deploy and initialise the server folder, the default
+  validate        Check the configuration without touching the disk or network
+  plan            Print what init would download, extract, overwrite and write
+  print-defaults  Print the default files init settings as JSON
+  schema          Print the JSON Schema of the files init settings
+
+Flags:
+`, path.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+}
+
+// runCommand runs a subcommand and returns the exit code of the process, failures during init still panic
+func runCommand(command string, stdout io.Writer, stderr io.Writer) int {
+	switch command {
+	case "", CommandInit:
+		if DryRun {
+			format := strings.ToUpper(DryRunFormat)
+			if !checkStringMatches(format, DryRunFormats) {
+				fmt.Fprintln(stderr, "Invalid dry run format "+DryRunFormat+", expected one of "+strings.Join(DryRunFormats, ", "))
+				return 2
+			}
+			printDryRunReport(stdout, format, dryRunInit(getEnvs(), ServerMountPath))
+			return 0
+		}
+		initServer(getEnvs(), ServerMountPath)
+	case CommandValidate:
+		if _, err := readEnvs(); err != nil {
+			fmt.Fprintln(stderr, err.Error())
+			return 1
+		}
+		fmt.Fprintln(stdout, "Configuration is valid")
+	case CommandPlan:
+		envs, err := readEnvs()
+		if err != nil {
+			fmt.Fprintln(stderr, err.Error())
+			return 1
+		}
+		printPlan(stdout, envs, ServerMountPath)
+	case CommandPrintDefaults:
+		printDefaults(stdout)
+	case CommandSchema:
+		printSchema(stdout)
+	default:
+		fmt.Fprintln(stderr, "Unknown command "+command+", expected one of "+strings.Join(Commands, ", "))
+		return 2
+	}
+	return 0
+}
+
+// initServer deploys the sources and initialises the server files
+func initServer(envs envs, serverFolderPath string) {
+	useDownloadCache(envs.cache)
+	useProxy(envs.proxy)
+	deploySources(envs.sources, serverFolderPath)
+	writeExtraFiles(envs.filesInit.ExtraFiles, serverFolderPath)
+	renderTemplateFiles(envs.filesInit, serverFolderPath)
+	applyConfigPatches(envs.filesInit.ConfigPatches, serverFolderPath)
+	writeJvmArgs(envs.filesInit, serverFolderPath)
+	startScript := resolveStartScript(envs, serverFolderPath)
+	// A custom start script is written executable by initServerFiles
+	if startScript.Method != StartScriptCustom {
+		err := ServerFiles.Chmod(path.Join(serverFolderPath, startScript.Path), 0755)
+		if err != nil {
+			panic("Failed to make start script " + startScript.Path + " executable: " + err.Error())
+		}
+		slog.Info(fmt.Sprintf("Successfully changed file permission for start script: %s", path.Join(serverFolderPath, startScript.Path)))
+	}
+	initServerFiles(envs.filesInit, startScript.Path, serverFolderPath)
+	sanitiseScripts(envs.filesInit, startScript.Path, serverFolderPath)
+	applyOwnership(envs.filesInit, startScript.Path, serverFolderPath)
+}
+
+// dryRunInit runs init against a recording file system, downloads and extraction still happen in a scratch folder
+func dryRunInit(envs envs, serverFolderPath string) dryRunReport {
+	dryRun := newDryRunFileSystem(serverFolderPath)
+	defer dryRun.Close()
+	defer func(serverFiles serverFileSystem) { ServerFiles = serverFiles }(ServerFiles)
+	ServerFiles = dryRun
+
+	initServer(envs, serverFolderPath)
+	return dryRunReport{ServerFolder: serverFolderPath, Changes: dryRun.changes()}
+}
+
+// printDefaults writes the default files init settings as indented JSON
+func printDefaults(w io.Writer) {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(filesInitDefault); err != nil {
+		panic("Failed to write defaults: " + err.Error())
+	}
+}
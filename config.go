@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFilePath is set by the -config flag, OMSMS_CONFIG_FILE is used when it is empty
+var ConfigFilePath = ""
+
+func init() {
+	flag.StringVar(&ConfigFilePath, "config", "", "Path to a JSON, YAML or TOML config file, overrides OMSMS_CONFIG_FILE")
+}
+
+// configFile is a mounted config document, every value in it can be overridden by the matching environment variable
+type configFile struct {
+	StartScriptName string
+	// Proxy overrides HTTP_PROXY and HTTPS_PROXY for downloads and clones
+	Proxy     string
+	Sources   []deploymentSource
+	FilesInit json.RawMessage
+}
+
+// getConfigFilePath returns the config file selected by flag or environment variable, empty if there is none
+func getConfigFilePath() string {
+	if ConfigFilePath != "" {
+		return ConfigFilePath
+	}
+	return os.Getenv("OMSMS_CONFIG_FILE")
+}
+
+// loadConfigFile decodes a JSON, YAML or TOML config file, the format is chosen by the file extension. YAML and TOML
+// documents are converted to JSON first, so field names match the same way as in the environment variables
+func loadConfigFile(filePath string, problems *validationErrors) configFile {
+	var config configFile
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		problems.add(filePath, "failed to read config file: "+err.Error())
+		return config
+	}
+
+	var document map[string]any
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".json":
+		err = json.Unmarshal(data, &document)
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(data, &document)
+	case ".toml":
+		err = toml.Unmarshal(data, &document)
+	default:
+		problems.add(filePath, "unsupported config file format, expected a .json, .yaml, .yml or .toml file")
+		return config
+	}
+	if err != nil {
+		problems.add(filePath, "failed to decode config file: "+err.Error())
+		return config
+	}
+
+	documentJson, err := json.Marshal(document)
+	if err != nil {
+		problems.add(filePath, "failed to convert config file: "+err.Error())
+		return config
+	}
+	decodeStrict("", documentJson, &config, problems)
+	return config
+}
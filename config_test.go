@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func writeTestConfigFile(t *testing.T, name string, content string) string {
+	filePath := path.Join(t.TempDir(), name)
+	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+func TestEnvParserWithConfigFiles(t *testing.T) {
+	configFiles := map[string]string{
+		"config.json": `{
+	"StartScriptName": "startserver.sh",
+	"Sources": [{"Type": "ZIP", "Url": "https://ducky.com/pack.zip"}],
+	"FilesInit": {"MaxPlayers": 20, "Motd": "Ducky"}
+}`,
+		"config.yaml": `startScriptName: startserver.sh
+sources:
+  - type: ZIP
+    url: https://ducky.com/pack.zip
+filesInit:
+  maxPlayers: 20
+  motd: Ducky
+`,
+		"config.toml": `StartScriptName = "startserver.sh"
+
+[[Sources]]
+Type = "ZIP"
+Url = "https://ducky.com/pack.zip"
+
+[FilesInit]
+MaxPlayers = 20
+Motd = "Ducky"
+`,
+	}
+	for name, content := range configFiles {
+		t.Setenv("OMSMS_CONFIG_FILE", writeTestConfigFile(t, name, content))
+
+		envs := getEnvs()
+
+		if envs.startScriptName != "startserver.sh" {
+			t.Fatalf("Expected start script startserver.sh from %s but got %s", name, envs.startScriptName)
+		}
+		if len(envs.sources) != 1 || envs.sources[0].Url != "https://ducky.com/pack.zip" {
+			t.Fatalf("Expected one source from %s but got %v", name, envs.sources)
+		}
+		if envs.filesInit.MaxPlayers != 20 || envs.filesInit.Motd != "Ducky" {
+			t.Fatalf("Expected files init from %s but got %v", name, envs.filesInit)
+		}
+		if envs.filesInit.ViewDistance != filesInitDefault.ViewDistance {
+			t.Fatalf("Expected default view distance but got %d", envs.filesInit.ViewDistance)
+		}
+	}
+}
+
+func TestEnvParserWithConfigFileAndEnvOverrides(t *testing.T) {
+	ConfigFilePath = writeTestConfigFile(t, "config.yml", `StartScriptName: startserver.sh
+Sources:
+  - Type: ZIP
+    Url: https://ducky.com/pack.zip
+FilesInit:
+  MaxPlayers: 20
+  Motd: Ducky
+`)
+	defer func() { ConfigFilePath = "" }()
+	t.Setenv("OMSMS_SERVER_FILES_INIT", `{"Motd": "Goose"}`)
+	t.Setenv("OMSMS_SERVER_DEPLOYMENT_TYPE", DeploymentTypeGit)
+	t.Setenv("OMSMS_SERVER_DEPLOYMENT_VALUE", "https://ducky.com/server.git")
+	t.Setenv("OMSMS_SERVER_START_SCRIPT_NAME", "run.sh")
+
+	envs := getEnvs()
+
+	if envs.startScriptName != "run.sh" {
+		t.Fatalf("Expected start script run.sh but got %s", envs.startScriptName)
+	}
+	if len(envs.sources) != 1 || envs.sources[0].Type != DeploymentTypeGit {
+		t.Fatalf("Expected the git source from the environment but got %v", envs.sources)
+	}
+	if envs.filesInit.MaxPlayers != 20 || envs.filesInit.Motd != "Goose" {
+		t.Fatalf("Expected max players from the file and motd from the environment but got %d and %s", envs.filesInit.MaxPlayers, envs.filesInit.Motd)
+	}
+}
+
+func TestReadEnvsWithDeploymentTypeAndConfigSources(t *testing.T) {
+	ConfigFilePath = writeTestConfigFile(t, "config.yml", `Sources:
+  - Type: ZIP
+    Url: https://ducky.com/pack.zip
+`)
+	defer func() { ConfigFilePath = "" }()
+	t.Setenv("OMSMS_SERVER_FILES_INIT", "{}")
+	t.Setenv("OMSMS_SERVER_DEPLOYMENT_TYPE", DeploymentTypeGit)
+
+	_, err := readEnvs()
+	problems, _ := err.(validationErrors)
+	expectProblem(t, problems, "OMSMS_SERVER_DEPLOYMENT_TYPE")
+}
+
+func TestEnvParserWithMissingConfigFile(t *testing.T) {
+	t.Setenv("OMSMS_CONFIG_FILE", path.Join(t.TempDir(), "config.yml"))
+	expectPanic(t, func() { getEnvs() })
+}
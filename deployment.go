@@ -0,0 +1,335 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	gitclient "github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+const (
+	ConflictPolicyOverwrite = "OVERWRITE"
+	ConflictPolicySkip      = "SKIP"
+	ConflictPolicyError     = "ERROR"
+)
+
+var ConflictPolicies = []string{ConflictPolicyOverwrite, ConflictPolicySkip, ConflictPolicyError}
+
+// deploymentSource is one layer of a deployment, layers are applied to the server folder in order
+type deploymentSource struct {
+	Type string
+	// Url has one of the DeploymentSchemes of Type, GIT sources also take the scp-like git@host:path form. ssh clones
+	// only work with an ssh agent, which the image does not ship, so private repositories are cloned over https
+	Url string
+	// Mirrors are tried in order when Url fails, they must serve the same content
+	Mirrors []string
+	// Hash optionally verifies the download of ZIP and FILE sources, formatted as algorithm:hex
+	Hash string
+	// Auth optionally authenticates the requests for Url and Mirrors
+	Auth *downloadAuth
+	// Target is the subdirectory of the server folder the source is deployed into
+	Target string
+	// FileName is the name of the downloaded file for FILE sources, defaults to the last element of the url path
+	FileName string
+	// Conflict decides what happens when the source contains a file that already exists, defaults to OVERWRITE
+	Conflict string
+
+	// Archive options for ZIP sources, a nil StripComponents means auto
+	StripComponents *int
+	Subpath         string
+
+	Include []string
+	Exclude []string
+
+	filter fileFilter
+}
+
+// urls returns the url of the source followed by its mirrors
+func (source deploymentSource) urls() []string {
+	return append([]string{source.Url}, source.Mirrors...)
+}
+
+// archiveOptions returns the extraction options of a ZIP source
+func (source deploymentSource) archiveOptions() archiveOptions {
+	options := archiveOptionsDefault
+	if source.StripComponents != nil {
+		options.StripComponents = *source.StripComponents
+	}
+	options.Subpath = source.Subpath
+	return options
+}
+
+// validateDeploymentSource checks a source and fills in its defaults, name maps its fields to the reported paths
+func validateDeploymentSource(name fieldNamer, source deploymentSource, problems *validationErrors) deploymentSource {
+	if !checkStringMatches(source.Type, DeploymentTypes) {
+		problems.add(name("Type"), "invalid deployment type "+strconv.Quote(source.Type)+", expected one of "+strings.Join(DeploymentTypes, ", "))
+	}
+	schemes, ok := DeploymentSchemes[source.Type]
+	if !ok {
+		schemes = DownloadSchemes
+	}
+	sshUrl := false
+	if sourceUrl, err := parseSourceURL(source.Url, schemes); err != nil {
+		problems.add(name("Url"), "invalid url "+strconv.Quote(redactString(source.Url))+": "+err.Error())
+	} else {
+		sshUrl = sourceUrl.Scheme == SchemeSsh
+	}
+	for _, mirror := range source.Mirrors {
+		if mirrorUrl, err := parseSourceURL(mirror, schemes); err != nil {
+			problems.add(name("Mirrors"), "invalid mirror url "+strconv.Quote(redactString(mirror))+": "+err.Error())
+		} else {
+			sshUrl = sshUrl || mirrorUrl.Scheme == SchemeSsh
+		}
+	}
+	// Auth is sent over http, the ssh transport cannot use it
+	if source.Auth != nil && sshUrl {
+		problems.add(name("Auth"), "auth is not supported for ssh urls, use an https url")
+	}
+	if source.Hash != "" {
+		if source.Type == DeploymentTypeGit {
+			problems.add(name("Hash"), "a hash needs a ZIP or FILE source")
+		} else if _, _, err := parseHash(source.Hash); err != nil {
+			problems.add(name("Hash"), "invalid hash: "+err.Error())
+		}
+	}
+	source.Auth = validateDownloadAuth(fieldPrefix(name("Auth")), source.Auth, problems)
+
+	target := cleanArchiveMemberName(source.Target)
+	if isEscapingPath(target) {
+		problems.add(name("Target"), "target "+strconv.Quote(source.Target)+" leaves the server folder")
+	}
+	source.Target = target
+
+	if source.Conflict == "" {
+		source.Conflict = ConflictPolicyOverwrite
+	}
+	if !checkStringMatches(source.Conflict, ConflictPolicies) {
+		problems.add(name("Conflict"), "invalid conflict policy "+strconv.Quote(source.Conflict)+", expected one of "+strings.Join(ConflictPolicies, ", "))
+	}
+
+	if source.Type == DeploymentTypeFile {
+		if source.FileName == "" {
+			parsedUrl, err := url.Parse(source.Url)
+			if err == nil {
+				source.FileName = path.Base(parsedUrl.Path)
+			}
+		}
+		if source.FileName == "" || source.FileName != path.Base(source.FileName) || source.FileName == "." || source.FileName == ".." || source.FileName == "/" {
+			problems.add(name("FileName"), "invalid file name "+strconv.Quote(source.FileName)+", set FileName explicitly")
+		}
+	}
+
+	if source.StripComponents != nil && *source.StripComponents < 0 {
+		problems.add(name("StripComponents"), strconv.Itoa(*source.StripComponents)+" is out of range, expected auto or a non-negative number")
+	}
+	if source.Subpath != "" {
+		subpath := cleanArchiveMemberName(source.Subpath)
+		if subpath == "." || isEscapingPath(subpath) {
+			problems.add(name("Subpath"), "invalid archive subpath "+strconv.Quote(source.Subpath))
+		}
+		source.Subpath = subpath
+	}
+
+	// Both lists are compiled on their own so a bad pattern is reported under the list it came from
+	include, err := compileGlobs(source.Include)
+	if err != nil {
+		problems.add(name("Include"), "invalid file filter: "+err.Error())
+	}
+	exclude, err := compileGlobs(source.Exclude)
+	if err != nil {
+		problems.add(name("Exclude"), "invalid file filter: "+err.Error())
+	}
+	source.filter = fileFilter{include: include, exclude: exclude}
+	return source
+}
+
+// deploySources applies every source to the server folder in order
+func deploySources(sources []deploymentSource, serverFolderPath string) {
+	if err := ServerFiles.MkdirAll(serverFolderPath, os.ModePerm); err != nil {
+		panic("Failed to create server folder: " + err.Error())
+	}
+	removeStaleStagingFolders(serverFolderPath)
+	for i, source := range sources {
+		slog.Info(fmt.Sprintf("Deploying source %d of %d: %s %s", i+1, len(sources), source.Type, source.Url))
+		deploySource(source, serverFolderPath)
+	}
+}
+
+// StagingFolderPrefix starts the name of the staging folders that deploySource creates inside the server folder
+const StagingFolderPrefix = ".omsms-staging-"
+
+// removeStaleStagingFolders deletes staging folders left behind by an init that was killed during a deployment
+func removeStaleStagingFolders(serverFolderPath string) {
+	entries, err := ServerFiles.ReadDir(serverFolderPath)
+	if err != nil {
+		panic("Failed to read server folder: " + err.Error())
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), StagingFolderPrefix) {
+			continue
+		}
+		slog.Warn("Removing stale staging folder " + entry.Name())
+		if err := ServerFiles.RemoveAll(filepath.Join(serverFolderPath, entry.Name())); err != nil {
+			panic("Failed to remove stale staging folder: " + err.Error())
+		}
+	}
+}
+
+// deploySource fetches a source into a staging folder and then merges it into its target, the staging folder
+// lives inside the server folder so the merge only has to rename files
+func deploySource(source deploymentSource, serverFolderPath string) {
+	targetPath, err := resolveArchiveMemberPath(serverFolderPath, source.Target)
+	if err != nil {
+		panic("Invalid deployment target: " + err.Error())
+	}
+
+	stagingPath, err := ServerFiles.MkdirTemp(serverFolderPath, StagingFolderPrefix+"*")
+	if err != nil {
+		panic("Failed to create staging folder: " + err.Error())
+	}
+	defer os.RemoveAll(stagingPath)
+
+	switch source.Type {
+	case DeploymentTypeZip:
+		slog.Info("Deploying server from archive...")
+		downloadAndExtractArchive(source.urls(), source.Hash, source.Auth, stagingPath, source.archiveOptions(), source.filter)
+
+	case DeploymentTypeGit:
+		slog.Info("Deploying server from git repository...")
+		cloneRepository(source.urls(), source.Auth, stagingPath)
+		// The layers are merged into one folder, so the repository metadata of a single layer is meaningless
+		if err := os.RemoveAll(filepath.Join(stagingPath, ".git")); err != nil {
+			panic("Failed to remove repository metadata: " + err.Error())
+		}
+		applyFileFilter(stagingPath, source.filter)
+
+	case DeploymentTypeFile:
+		slog.Info("Deploying single file " + source.FileName + "...")
+		if !source.filter.allows(source.FileName) {
+			slog.Debug("Filtered out file " + source.FileName)
+			return
+		}
+		file, err := os.Create(filepath.Join(stagingPath, source.FileName))
+		if err != nil {
+			panic("Failed to create file " + source.FileName + ": " + err.Error())
+		}
+		downloadFile(source.urls(), source.Hash, source.Auth, file)
+		file.Close()
+	}
+
+	if err := ServerFiles.MkdirAll(targetPath, os.ModePerm); err != nil {
+		panic("Failed to create deployment target: " + err.Error())
+	}
+	mergeDirectory(stagingPath, targetPath, source.Conflict)
+}
+
+// cloneRepository clones the first of urls that answers into path, the urls after the first are mirrors of the same
+// repository tried in order. auth is used for every url and may be nil
+func cloneRepository(urls []string, auth *downloadAuth, path string) {
+	// Clones go through the same proxy and present the same client certificate as downloads
+	transport := newTransport(auth)
+	defer transport.CloseIdleConnections()
+	gitClient := githttp.NewClient(&http.Client{Transport: transport, CheckRedirect: auth.checkRedirect})
+	gitclient.InstallProtocol("http", gitClient)
+	gitclient.InstallProtocol("https", gitClient)
+
+	var failures []string
+	for i, url := range urls {
+		options := &git.CloneOptions{
+			URL:      url,
+			Progress: os.Stdout,
+		}
+		if auth != nil {
+			options.Auth = auth
+		}
+		_, err := git.PlainClone(path, false, options)
+		if err == nil {
+			if len(urls) > 1 {
+				slog.Info(fmt.Sprintf("Cloned %s from mirror %d of %d", url, i+1, len(urls)))
+			}
+			return
+		}
+		if len(urls) > 1 {
+			slog.Warn(fmt.Sprintf("Failed to clone mirror %d of %d %s: %s", i+1, len(urls), url, err.Error()))
+		}
+		failures = append(failures, url+": "+err.Error())
+		// The next mirror needs an empty folder again
+		if err := os.RemoveAll(path); err != nil {
+			panic("Failed to clean up failed clone: " + err.Error())
+		}
+		if err := os.MkdirAll(path, os.ModePerm); err != nil {
+			panic("Failed to clean up failed clone: " + err.Error())
+		}
+	}
+	panic(redactString("Failed to clone repository: " + strings.Join(failures, "; ")))
+}
+
+// mergeDirectory moves everything in src into dst, conflict decides what happens to paths that exist in both
+func mergeDirectory(src string, dst string, conflict string) {
+	moved, overwritten, skipped := 0, 0, 0
+	err := filepath.WalkDir(src, func(srcPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, srcPath)
+		if err != nil || rel == "." {
+			return err
+		}
+		dstPath, err := resolveArchiveMemberPath(dst, filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+
+		existing, err := ServerFiles.Lstat(dstPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err == nil {
+			// Directories on both sides are merged instead of replaced
+			if d.IsDir() && existing.IsDir() {
+				return nil
+			}
+
+			switch conflict {
+			case ConflictPolicySkip:
+				slog.Debug("Keeping existing " + dstPath)
+				skipped++
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			case ConflictPolicyError:
+				return errors.New(dstPath + " already exists")
+			}
+			slog.Debug("Overwriting existing " + dstPath)
+			overwritten++
+			if err := ServerFiles.RemoveAll(dstPath); err != nil {
+				return err
+			}
+		}
+
+		if err := ServerFiles.Rename(srcPath, dstPath); err != nil {
+			return err
+		}
+		moved++
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		panic("Failed to merge deployment into " + dst + ": " + err.Error())
+	}
+	slog.Info(fmt.Sprintf("Merged deployment into %s: moved %d paths, overwrote %d, kept %d existing", dst, moved, overwritten, skipped))
+}
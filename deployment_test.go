@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+func serveTestFiles(t *testing.T, files map[string]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filePath, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, filePath)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func writeTestFile(t *testing.T, content string) string {
+	filePath := path.Join(t.TempDir(), "file")
+	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+func TestDeploySourcesInLayers(t *testing.T) {
+	server := serveTestFiles(t, map[string]string{
+		"/base.zip": writeTestZip(t, []testArchiveMember{
+			{Name: "Pack/mods/ducky.jar", Content: "quack"},
+			{Name: "Pack/config/ducky.toml", Content: "loud=false"},
+			{Name: "Pack/startserver.sh", Content: "java -jar server.jar"},
+		}),
+		"/overlay.tar.gz": writeTestTar(t, ArchiveFormatTarGz, []testArchiveMember{
+			{Name: "config/ducky.toml", Content: "loud=true"},
+			{Name: "startserver.sh", Content: "echo overlay"},
+		}),
+		"/goose.jar": writeTestFile(t, "honk"),
+	})
+	serverDir := t.TempDir()
+
+	sources := []deploymentSource{
+		{Type: DeploymentTypeZip, Url: server.URL + "/base.zip"},
+		{Type: DeploymentTypeZip, Url: server.URL + "/overlay.tar.gz", Exclude: []string{"*.sh"}},
+		{Type: DeploymentTypeFile, Url: server.URL + "/goose.jar", Target: "mods"},
+		{Type: DeploymentTypeFile, Url: server.URL + "/goose.jar", Target: "config", FileName: "ducky.toml", Conflict: ConflictPolicySkip},
+	}
+	for i, source := range sources {
+		sources[i] = expectValid(t, validateDeploymentSource, source)
+	}
+	deploySources(sources, serverDir)
+
+	expectFileContent(t, path.Join(serverDir, "mods", "ducky.jar"), "quack")
+	expectFileContent(t, path.Join(serverDir, "mods", "goose.jar"), "honk")
+	expectFileContent(t, path.Join(serverDir, "config", "ducky.toml"), "loud=true")
+	expectFileContent(t, path.Join(serverDir, "startserver.sh"), "java -jar server.jar")
+
+	entries, err := os.ReadDir(serverDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected staging folders to be removed but found %d entries in %s", len(entries), serverDir)
+	}
+}
+
+func TestDeploySourcesRemovesStaleStagingFolders(t *testing.T) {
+	server := serveTestFiles(t, map[string]string{
+		"/ducky.jar": writeTestFile(t, "quack"),
+	})
+	serverDir := t.TempDir()
+	if err := os.MkdirAll(path.Join(serverDir, StagingFolderPrefix+"1234", "mods"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	deploySources([]deploymentSource{
+		expectValid(t, validateDeploymentSource, deploymentSource{Type: DeploymentTypeFile, Url: server.URL + "/ducky.jar"}),
+	}, serverDir)
+
+	entries, err := os.ReadDir(serverDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "ducky.jar" {
+		t.Fatalf("Expected only ducky.jar to be left in %s but found %v", serverDir, entries)
+	}
+}
+
+func TestDeploySourcesWithConflictError(t *testing.T) {
+	server := serveTestFiles(t, map[string]string{
+		"/ducky.jar": writeTestFile(t, "quack"),
+	})
+	serverDir := t.TempDir()
+
+	sources := []deploymentSource{
+		expectValid(t, validateDeploymentSource, deploymentSource{Type: DeploymentTypeFile, Url: server.URL + "/ducky.jar"}),
+		expectValid(t, validateDeploymentSource, deploymentSource{Type: DeploymentTypeFile, Url: server.URL + "/ducky.jar", Conflict: ConflictPolicyError}),
+	}
+	expectPanic(t, func() { deploySources(sources, serverDir) })
+}
+
+func TestValidateDeploymentSourceWithEscapingTarget(t *testing.T) {
+	expectInvalid(t, validateDeploymentSource, deploymentSource{Type: DeploymentTypeZip, Url: "https://ducky.com/pack.zip", Target: "../ducky"}, "Target")
+}
+
+func TestValidateDeploymentSourceWithMirrors(t *testing.T) {
+	expectInvalid(t, validateDeploymentSource, deploymentSource{Type: DeploymentTypeZip, Url: "https://ducky.com/pack.zip", Mirrors: []string{"not a url"}}, "Mirrors")
+	expectInvalid(t, validateDeploymentSource, deploymentSource{Type: DeploymentTypeZip, Url: "https://ducky.com/pack.zip", Hash: "sha256:1234"}, "Hash")
+	expectInvalid(t, validateDeploymentSource, deploymentSource{Type: DeploymentTypeGit, Url: "https://ducky.com/config.git", Hash: "sha1:0000000000000000000000000000000000000000"}, "Hash")
+}
+
+func TestValidateDeploymentSourceWithSshAuth(t *testing.T) {
+	auth := &downloadAuth{Username: "ducky", Password: "honk"}
+	expectValid(t, validateDeploymentSource, deploymentSource{Type: DeploymentTypeGit, Url: "https://ducky.com/config.git", Auth: auth})
+	expectValid(t, validateDeploymentSource, deploymentSource{Type: DeploymentTypeGit, Url: "git@github.com:ducky/config.git"})
+	expectInvalid(t, validateDeploymentSource, deploymentSource{Type: DeploymentTypeGit, Url: "git@github.com:ducky/config.git", Auth: auth}, "Auth")
+	expectInvalid(t, validateDeploymentSource, deploymentSource{Type: DeploymentTypeGit, Url: "https://ducky.com/config.git", Mirrors: []string{"ssh://git@ducky.com/config.git"}, Auth: auth}, "Auth")
+}
+
+func TestEnvParserWithSingleSourceMirrors(t *testing.T) {
+	t.Setenv("OMSMS_SERVER_FILES_INIT", "{}")
+	t.Setenv("OMSMS_SERVER_DEPLOYMENT_TYPE", "ZIP")
+	t.Setenv("OMSMS_SERVER_DEPLOYMENT_VALUE", "https://mediafilez.forgecdn.net/pack.zip")
+	t.Setenv("OMSMS_SERVER_DEPLOYMENT_MIRRORS", "https://edge.forgecdn.net/pack.zip, https://ducky.com/pack.zip")
+	t.Setenv("OMSMS_SERVER_DEPLOYMENT_HASH", "sha1:0000000000000000000000000000000000000000")
+
+	envs := getEnvs()
+
+	urls := envs.sources[0].urls()
+	if len(urls) != 3 || urls[1] != "https://edge.forgecdn.net/pack.zip" || urls[2] != "https://ducky.com/pack.zip" {
+		t.Fatalf("Expected the url followed by both mirrors but got %v", urls)
+	}
+	if envs.sources[0].Hash != "sha1:0000000000000000000000000000000000000000" {
+		t.Fatalf("Expected the hash to be set but got %q", envs.sources[0].Hash)
+	}
+}
+
+func TestEnvParserWithSourceList(t *testing.T) {
+	t.Setenv("OMSMS_SERVER_FILES_INIT", "{}")
+	t.Setenv("OMSMS_SERVER_DEPLOYMENT_VALUE", `[
+		{"Type": "ZIP", "Url": "https://ducky.com/pack.zip", "StripComponents": 2},
+		{"Type": "GIT", "Url": "https://ducky.com/config.git", "Conflict": "SKIP"},
+		{"Type": "FILE", "Url": "https://ducky.com/mods/goose.jar", "Target": "mods"}
+	]`)
+	t.Setenv("OMSMS_SERVER_START_SCRIPT_NAME", "startserver.sh")
+
+	envs := getEnvs()
+
+	if len(envs.sources) != 3 {
+		t.Fatalf("Expected 3 sources but got %d", len(envs.sources))
+	}
+	if options := envs.sources[0].archiveOptions(); options.StripComponents != 2 {
+		t.Fatalf("Expected strip components to be 2 but got %d", options.StripComponents)
+	}
+	if envs.sources[0].Conflict != ConflictPolicyOverwrite || envs.sources[1].Conflict != ConflictPolicySkip {
+		t.Fatalf("Expected conflict policies OVERWRITE and SKIP but got %s and %s", envs.sources[0].Conflict, envs.sources[1].Conflict)
+	}
+	if envs.sources[2].FileName != "goose.jar" {
+		t.Fatalf("Expected file name goose.jar but got %s", envs.sources[2].FileName)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// DownloadAttempts is how often a download is tried before giving up, the delay between attempts doubles from
+	// DownloadRetryDelay up to DownloadMaxRetryDelay
+	DownloadAttempts      = 5
+	DownloadRetryDelay    = time.Second
+	DownloadMaxRetryDelay = 30 * time.Second
+	// DownloadProgressInterval is how often the progress of a running download is logged
+	DownloadProgressInterval = 5 * time.Second
+)
+
+// downloadFile writes the content found at the first of urls that answers to dst, the urls after the first are
+// mirrors of the same file tried in order. A checksum, when not empty, must match whichever mirror answered. auth is
+// used for every url and may be nil
+func downloadFile(urls []string, checksum string, auth *downloadAuth, dst *os.File) {
+	var failures []string
+	for i, url := range urls {
+		err := fetchMirror(url, checksum, auth, dst)
+		if err == nil {
+			if len(urls) > 1 {
+				slog.Info(fmt.Sprintf("Downloaded %s from mirror %d of %d", url, i+1, len(urls)))
+			}
+			return
+		}
+		if len(urls) > 1 {
+			slog.Warn(fmt.Sprintf("Failed to download from mirror %d of %d %s: %s", i+1, len(urls), url, err.Error()))
+		}
+		failures = append(failures, url+": "+err.Error())
+	}
+	// Panics are not logged through the redacting handler, so tokens in the urls are removed here
+	panic(redactString("Failed to download to " + dst.Name() + ", error: " + strings.Join(failures, "; ")))
+}
+
+// fetchMirror replaces the content of dst with the content at url and verifies it against checksum
+func fetchMirror(url string, checksum string, auth *downloadAuth, dst *os.File) error {
+	// A failed mirror may have left a partial download behind
+	if err := dst.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := dst.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	sourceUrl, err := parseSourceURL(url, DownloadSchemes)
+	if err != nil {
+		return err
+	}
+	if err := urlFetchers[sourceUrl.Scheme](sourceUrl, checksum, auth, dst); err != nil {
+		return err
+	}
+	if checksum != "" {
+		return verifyFileHash(dst.Name(), checksum)
+	}
+	return nil
+}
+
+// downloadBytes returns the content found at the first of urls that answers, for small files like the server icon
+func downloadBytes(urls []string) []byte {
+	tmpFile, err := os.CreateTemp("", "download-*")
+	if err != nil {
+		panic("Failed to create temporary file: " + err.Error())
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	downloadFile(urls, "", nil, tmpFile)
+	content, err := os.ReadFile(tmpFile.Name())
+	if err != nil {
+		panic(redactString("Failed to read download from " + urls[0] + ", error: " + err.Error()))
+	}
+	return content
+}
+
+// retryableError is a failed attempt that is worth repeating, like a dropped connection or a 503
+type retryableError struct {
+	err error
+}
+
+func (e retryableError) Error() string {
+	return e.err.Error()
+}
+
+// fetchURL downloads url into dst, which must be empty. Failed attempts are retried with exponential backoff and
+// resume where the last one stopped when the server supports range requests. It returns the response of the last
+// attempt with its body closed, a 304 to a conditional request in header leaves dst empty
+func fetchURL(url string, auth *downloadAuth, header http.Header, dst *os.File) (*http.Response, error) {
+	transport := newTransport(auth)
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport, CheckRedirect: auth.checkRedirect}
+
+	delay := DownloadRetryDelay
+	var validator string
+	for attempt := 1; ; attempt++ {
+		resp, err := fetchAttempt(client, url, auth, header, dst, &validator)
+		if err == nil {
+			return resp, nil
+		}
+		var retryable retryableError
+		if !errors.As(err, &retryable) || attempt >= DownloadAttempts {
+			return nil, err
+		}
+		written, _ := dst.Seek(0, io.SeekCurrent)
+		slog.Warn(fmt.Sprintf("Attempt %d of %d to download %s failed after %s, retrying in %s: %s", attempt, DownloadAttempts, url, formatBytes(written), delay, err.Error()))
+		time.Sleep(delay)
+		delay = min(delay*2, DownloadMaxRetryDelay)
+	}
+}
+
+// fetchAttempt makes one request for url, continuing after what dst already holds. validator is the ETag or
+// Last-Modified of the first response, a resumed response must still be for the same content
+func fetchAttempt(client *http.Client, url string, auth *downloadAuth, header http.Header, dst *os.File, validator *string) (*http.Response, error) {
+	offset, err := dst.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if auth != nil {
+		auth.SetAuth(req)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if *validator != "" {
+			req.Header.Set("If-Range", *validator)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, retryableError{err}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		return resp, nil
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		var start int64
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-", &start); err != nil || start != offset {
+			return nil, errors.New("unexpected content range " + strconv.Quote(resp.Header.Get("Content-Range")) + " when resuming at byte " + strconv.FormatInt(offset, 10))
+		}
+		slog.Info(fmt.Sprintf("Resuming download of %s at %s", url, formatBytes(offset)))
+	case resp.StatusCode == http.StatusOK:
+		// The server ignored the range or the content changed, so the download starts over
+		if offset > 0 {
+			slog.Info("Server does not support resuming, restarting download of " + url)
+			if err := dst.Truncate(0); err != nil {
+				return nil, err
+			}
+			if _, err := dst.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			offset = 0
+		}
+		*validator = resp.Header.Get("ETag")
+		if *validator == "" {
+			*validator = resp.Header.Get("Last-Modified")
+		}
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests:
+		return nil, retryableError{errors.New("unexpected status " + resp.Status)}
+	default:
+		return nil, errors.New("unexpected status " + resp.Status)
+	}
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	progress := newDownloadProgress(url, offset, total)
+	written, err := io.Copy(io.MultiWriter(dst, progress), resp.Body)
+	if err != nil {
+		return nil, retryableError{err}
+	}
+	if resp.ContentLength >= 0 && written != resp.ContentLength {
+		return nil, retryableError{fmt.Errorf("received %d of %d bytes", written, resp.ContentLength)}
+	}
+	progress.done()
+	return resp, nil
+}
+
+// downloadProgress logs the progress of a download at most every DownloadProgressInterval
+type downloadProgress struct {
+	url      string
+	start    time.Time
+	resumed  int64
+	received int64
+	total    int64
+	lastLog  time.Time
+}
+
+func newDownloadProgress(url string, resumed int64, total int64) *downloadProgress {
+	now := time.Now()
+	return &downloadProgress{url: url, start: now, resumed: resumed, total: total, lastLog: now}
+}
+
+func (p *downloadProgress) Write(data []byte) (int, error) {
+	p.received += int64(len(data))
+	if time.Since(p.lastLog) >= DownloadProgressInterval {
+		p.lastLog = time.Now()
+		slog.Info(p.String())
+	}
+	return len(data), nil
+}
+
+func (p *downloadProgress) done() {
+	// Short downloads are not worth a summary
+	if time.Since(p.start) >= DownloadProgressInterval {
+		slog.Info(p.String())
+	}
+}
+
+// String reads like "Downloaded 1.2 GiB of 3.0 GiB (40%) from url at 12.5 MiB/s, 2m27s left"
+func (p *downloadProgress) String() string {
+	elapsed := time.Since(p.start)
+	rate := float64(p.received) / max(elapsed.Seconds(), 0.001)
+	done := p.resumed + p.received
+	if p.total < 0 {
+		return fmt.Sprintf("Downloaded %s from %s at %s/s", formatBytes(done), p.url, formatBytes(int64(rate)))
+	}
+	left := "unknown time"
+	if rate > 0 {
+		left = (time.Duration(float64(p.total-done)/rate) * time.Second).Round(time.Second).String()
+	}
+	return fmt.Sprintf("Downloaded %s of %s (%d%%) from %s at %s/s, %s left",
+		formatBytes(done), formatBytes(p.total), done*100/max(p.total, 1), p.url, formatBytes(int64(rate)), left)
+}
+
+// formatBytes formats a byte count with binary units
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	value, exponent := float64(bytes)/unit, 0
+	for value >= unit && exponent < 4 {
+		value /= unit
+		exponent++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTP"[exponent])
+}
+
+var hashAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// parseHash splits an algorithm:hex checksum and checks that both parts are usable
+func parseHash(checksum string) (string, string, error) {
+	algorithm, digest, found := strings.Cut(checksum, ":")
+	if !found {
+		return "", "", errors.New("expected algorithm:hex, got " + checksum)
+	}
+	algorithm = strings.ToLower(algorithm)
+	newHash, ok := hashAlgorithms[algorithm]
+	if !ok {
+		return "", "", errors.New("unsupported hash algorithm " + algorithm)
+	}
+	decoded, err := hex.DecodeString(digest)
+	if err != nil || len(decoded) != newHash().Size() {
+		return "", "", errors.New("invalid " + algorithm + " digest " + digest)
+	}
+	return algorithm, strings.ToLower(digest), nil
+}
+
+// verifyFileHash compares the checksum of the file at filePath with an algorithm:hex checksum
+func verifyFileHash(filePath string, checksum string) error {
+	algorithm, expected, err := parseHash(checksum)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hasher := hashAlgorithms[algorithm]()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return err
+	}
+	actual := hex.EncodeToString(hasher.Sum(nil))
+	if actual != expected {
+		return errors.New(algorithm + " mismatch, expected " + expected + " but got " + actual)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func setFastRetries(t *testing.T) {
+	attempts, delay := DownloadAttempts, DownloadRetryDelay
+	t.Cleanup(func() { DownloadAttempts, DownloadRetryDelay = attempts, delay })
+	DownloadAttempts, DownloadRetryDelay = 3, time.Millisecond
+}
+
+// newFlakyServer serves content, the first response is cut off after half of it. With ranges it honours Range
+// requests like a CDN would
+func newFlakyServer(t *testing.T, content string, ranges bool, requests *atomic.Int32) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := requests.Add(1)
+		w.Header().Set("ETag", `"ducky"`)
+		body := content
+		if start := strings.TrimPrefix(r.Header.Get("Range"), "bytes="); ranges && start != "" {
+			var offset int
+			fmt.Sscanf(start, "%d-", &offset)
+			body = content[offset:]
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(content)-1, len(content)))
+			w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+			w.WriteHeader(http.StatusPartialContent)
+		} else {
+			w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		}
+		if request == 1 {
+			// Promise the whole body but drop the connection halfway
+			w.Write([]byte(body[:len(body)/2]))
+			w.(http.Flusher).Flush()
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func downloadTestFile(t *testing.T, url string) string {
+	t.Helper()
+	dst, err := os.CreateTemp(t.TempDir(), "download-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	downloadFile([]string{url}, "", nil, dst)
+	content, err := os.ReadFile(dst.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestDownloadResumesAfterDroppedConnection(t *testing.T) {
+	setFastRetries(t)
+	content := strings.Repeat("quack", 10000)
+	for _, ranges := range []bool{true, false} {
+		var requests atomic.Int32
+		server := newFlakyServer(t, content, ranges, &requests)
+		if downloaded := downloadTestFile(t, server.URL+"/pack.zip"); downloaded != content {
+			t.Fatalf("Expected the whole content with ranges %v but got %d bytes", ranges, len(downloaded))
+		}
+		if requests.Load() != 2 {
+			t.Fatalf("Expected 2 requests with ranges %v but got %d", ranges, requests.Load())
+		}
+	}
+}
+
+func TestDownloadRetriesServerErrors(t *testing.T) {
+	setFastRetries(t)
+	var requests, failures atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if failures.Add(-1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "honk")
+	}))
+	defer server.Close()
+
+	failures.Store(2)
+	if downloaded := downloadTestFile(t, server.URL+"/goose.jar"); downloaded != "honk" {
+		t.Fatalf("Expected honk but got %q", downloaded)
+	}
+	if requests.Load() != 3 {
+		t.Fatalf("Expected 3 attempts but got %d", requests.Load())
+	}
+
+	requests.Store(0)
+	failures.Store(100)
+	expectPanic(t, func() {
+		downloadTestFile(t, server.URL+"/goose.jar")
+	})
+	if int(requests.Load()) != DownloadAttempts {
+		t.Fatalf("Expected %d attempts but got %d", DownloadAttempts, requests.Load())
+	}
+}
+
+func TestDownloadDoesNotRetryClientErrors(t *testing.T) {
+	setFastRetries(t)
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	expectPanic(t, func() {
+		downloadTestFile(t, server.URL+"/missing.zip")
+	})
+	if requests.Load() != 1 {
+		t.Fatalf("Expected a single attempt but got %d", requests.Load())
+	}
+}
+
+func TestDownloadProgress(t *testing.T) {
+	progress := &downloadProgress{url: "https://ducky.com/pack.zip", start: time.Now().Add(-10 * time.Second), resumed: 1 << 30, received: 1 << 30, total: 4 << 30}
+	expected := "Downloaded 2.0 GiB of 4.0 GiB (50%) from https://ducky.com/pack.zip at 102.4 MiB/s, 20s left"
+	if progress.String() != expected {
+		t.Fatalf("Expected %q but got %q", expected, progress.String())
+	}
+	for bytes, expected := range map[int64]string{512: "512 B", 1536: "1.5 KiB", 3 << 40: "3.0 TiB"} {
+		if formatBytes(bytes) != expected {
+			t.Fatalf("Expected %d bytes to be %s but got %s", bytes, expected, formatBytes(bytes))
+		}
+	}
+}
+
+func TestDownloadFallsBackToMirrors(t *testing.T) {
+	setFastRetries(t)
+	var requests sync.Map
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Store(r.URL.Path, true)
+		switch r.URL.Path {
+		case "/down/pack.zip":
+			http.NotFound(w, r)
+		case "/stale/pack.zip":
+			fmt.Fprint(w, "goose")
+		default:
+			fmt.Fprint(w, "ducky")
+		}
+	}))
+	defer server.Close()
+	digest := sha256.Sum256([]byte("ducky"))
+	checksum := "sha256:" + hex.EncodeToString(digest[:])
+	urls := []string{server.URL + "/down/pack.zip", server.URL + "/stale/pack.zip", server.URL + "/cdn/pack.zip"}
+
+	dst, err := os.CreateTemp(t.TempDir(), "download-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	downloadFile(urls, checksum, nil, dst)
+	content, err := os.ReadFile(dst.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "ducky" {
+		t.Fatalf("Expected the content of the last mirror but got %q", content)
+	}
+	for _, url := range urls {
+		if _, ok := requests.Load(strings.TrimPrefix(url, server.URL)); !ok {
+			t.Fatalf("Expected %s to be tried", url)
+		}
+	}
+
+	expectPanic(t, func() {
+		downloadFile(urls[:2], checksum, nil, dst)
+	})
+}
+
+func TestDownloadFromFileUrls(t *testing.T) {
+	packPath := writeTestConfigFile(t, "pack.zip", "ducky")
+
+	if content := string(downloadBytes([]string{"file://" + packPath})); content != "ducky" {
+		t.Fatalf("Expected the content of the local file but got %q", content)
+	}
+}
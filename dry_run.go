@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/magiconair/properties"
+)
+
+const (
+	ChangeAdded       = "ADDED"
+	ChangeModified    = "MODIFIED"
+	ChangeRemoved     = "REMOVED"
+	ChangePermissions = "PERMISSIONS"
+)
+
+const (
+	DryRunFormatText = "TEXT"
+	DryRunFormatJson = "JSON"
+)
+
+var DryRunFormats = []string{DryRunFormatText, DryRunFormatJson}
+
+// fileChange is one difference between the server folder before and after a dry run, paths are relative to the
+// server folder and sizes are in bytes
+type fileChange struct {
+	Path       string
+	Change     string
+	IsDir      bool
+	OldSize    int64
+	Size       int64
+	OldMode    string
+	Mode       string
+	Properties []propertyChange
+}
+
+// propertyChange is a server.properties key whose value changes, an empty side means the key is missing there
+type propertyChange struct {
+	Key      string
+	OldValue string
+	Value    string
+}
+
+type dryRunReport struct {
+	ServerFolder string
+	Changes      []fileChange
+}
+
+// dryRunEntry overlays a path of the server folder, everything below it resolves into path unless a deeper entry
+// overlays it again
+type dryRunEntry struct {
+	path    string
+	removed bool
+	mode    *os.FileMode
+}
+
+// dryRunFileSystem records changes to the server folder instead of applying them. Written and staged files live in
+// a scratch folder and the overlay maps server folder paths to them, so later steps read what earlier steps wrote
+type dryRunFileSystem struct {
+	root    string
+	scratch string
+	entries map[string]dryRunEntry
+	next    int
+}
+
+func newDryRunFileSystem(serverFolderPath string) *dryRunFileSystem {
+	scratch, err := os.MkdirTemp("", "omsms-dry-run-*")
+	if err != nil {
+		panic("Failed to create dry run folder: " + err.Error())
+	}
+	return &dryRunFileSystem{
+		root:    filepath.Clean(serverFolderPath),
+		scratch: scratch,
+		entries: map[string]dryRunEntry{},
+	}
+}
+
+// Close removes the scratch folder
+func (d *dryRunFileSystem) Close() {
+	os.RemoveAll(d.scratch)
+}
+
+// resolve returns the real path holding name after the recorded changes, false if name was removed
+func (d *dryRunFileSystem) resolve(name string) (string, *os.FileMode, bool) {
+	name = filepath.Clean(name)
+	for current := name; ; current = filepath.Dir(current) {
+		if entry, ok := d.entries[current]; ok {
+			if entry.removed {
+				return "", nil, false
+			}
+			rel, _ := filepath.Rel(current, name)
+			if current == name {
+				return entry.path, entry.mode, true
+			}
+			return filepath.Join(entry.path, rel), nil, true
+		}
+		if filepath.Dir(current) == current {
+			return name, nil, true
+		}
+	}
+}
+
+// overlay records entry for name, deeper entries are replaced by it
+func (d *dryRunFileSystem) overlay(name string, entry dryRunEntry) {
+	name = filepath.Clean(name)
+	for key := range d.entries {
+		if strings.HasPrefix(key, name+string(filepath.Separator)) {
+			delete(d.entries, key)
+		}
+	}
+	d.entries[name] = entry
+}
+
+func (d *dryRunFileSystem) scratchPath() string {
+	d.next++
+	return filepath.Join(d.scratch, strconv.Itoa(d.next))
+}
+
+func (d *dryRunFileSystem) inScratch(realPath string) bool {
+	return strings.HasPrefix(realPath, d.scratch+string(filepath.Separator))
+}
+
+func (d *dryRunFileSystem) Lstat(name string) (os.FileInfo, error) {
+	realPath, mode, ok := d.resolve(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrNotExist}
+	}
+	info, err := os.Lstat(realPath)
+	if err != nil || mode == nil {
+		return info, err
+	}
+	return modeFileInfo{FileInfo: info, mode: info.Mode().Type() | *mode}, nil
+}
+
+func (d *dryRunFileSystem) ReadFile(name string) ([]byte, error) {
+	realPath, _, ok := d.resolve(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return os.ReadFile(realPath)
+}
+
+// ReadDir lists name on disk together with the overlaid entries directly below it
+func (d *dryRunFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	realPath, _, ok := d.resolve(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	dirEntries, err := os.ReadDir(realPath)
+	if err != nil {
+		return nil, err
+	}
+	name = filepath.Clean(name)
+	children := map[string]bool{}
+	for _, entry := range dirEntries {
+		children[entry.Name()] = true
+	}
+	for key := range d.entries {
+		if filepath.Dir(key) == name {
+			children[filepath.Base(key)] = true
+		}
+	}
+	var result []os.DirEntry
+	for _, child := range sortedKeys(children) {
+		info, err := d.Lstat(filepath.Join(name, child))
+		if err != nil {
+			continue
+		}
+		// Overlaid entries live in the scratch folder under another name
+		result = append(result, fs.FileInfoToDirEntry(namedFileInfo{FileInfo: info, name: child}))
+	}
+	return result, nil
+}
+
+func (d *dryRunFileSystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	scratchPath := d.scratchPath()
+	if err := os.WriteFile(scratchPath, data, perm); err != nil {
+		return err
+	}
+	// Writing an existing file keeps its permissions
+	if info, err := d.Lstat(name); err == nil && info.Mode().IsRegular() {
+		if err := os.Chmod(scratchPath, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	d.overlay(name, dryRunEntry{path: scratchPath})
+	return nil
+}
+
+func (d *dryRunFileSystem) MkdirAll(name string, perm os.FileMode) error {
+	if info, err := d.Lstat(name); err == nil && info.IsDir() {
+		return nil
+	}
+	if parent := filepath.Dir(name); parent != name {
+		if err := d.MkdirAll(parent, perm); err != nil {
+			return err
+		}
+	}
+	realPath, _, _ := d.resolve(name)
+	if d.inScratch(realPath) {
+		return os.Mkdir(realPath, perm)
+	}
+	scratchPath := d.scratchPath()
+	if err := os.Mkdir(scratchPath, perm); err != nil {
+		return err
+	}
+	d.overlay(name, dryRunEntry{path: scratchPath})
+	return nil
+}
+
+func (d *dryRunFileSystem) MkdirTemp(_ string, pattern string) (string, error) {
+	return os.MkdirTemp(d.scratch, pattern)
+}
+
+func (d *dryRunFileSystem) CreateTemp(_ string, pattern string) (*os.File, error) {
+	return os.CreateTemp(d.scratch, pattern)
+}
+
+func (d *dryRunFileSystem) Rename(oldPath string, newPath string) error {
+	// Staging folders are removed after a source is deployed, so the staged files are moved out of them
+	scratchPath := d.scratchPath()
+	if err := os.Rename(oldPath, scratchPath); err != nil {
+		return err
+	}
+	d.overlay(newPath, dryRunEntry{path: scratchPath})
+	return nil
+}
+
+func (d *dryRunFileSystem) RemoveAll(name string) error {
+	d.overlay(name, dryRunEntry{removed: true})
+	return nil
+}
+
+func (d *dryRunFileSystem) Chmod(name string, mode os.FileMode) error {
+	realPath, _, ok := d.resolve(name)
+	if !ok {
+		return &fs.PathError{Op: "chmod", Path: name, Err: fs.ErrNotExist}
+	}
+	if d.inScratch(realPath) {
+		return os.Chmod(realPath, mode)
+	}
+	if _, err := os.Lstat(realPath); err != nil {
+		return err
+	}
+	perm := mode.Perm()
+	d.overlay(name, dryRunEntry{path: realPath, mode: &perm})
+	return nil
+}
+
+// Lchown only checks that name exists, ownership is not part of the dry run report
+func (d *dryRunFileSystem) Lchown(name string, _ int, _ int) error {
+	_, err := d.Lstat(name)
+	return err
+}
+
+type modeFileInfo struct {
+	os.FileInfo
+	mode os.FileMode
+}
+
+func (info modeFileInfo) Mode() os.FileMode {
+	return info.mode
+}
+
+type namedFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (info namedFileInfo) Name() string {
+	return info.name
+}
+
+// fileState is a path of the server folder on one side of the comparison
+type fileState struct {
+	realPath string
+	info     os.FileInfo
+}
+
+// changes compares the server folder on disk with the overlay, only the overlaid paths can differ
+func (d *dryRunFileSystem) changes() []fileChange {
+	var changes []fileChange
+	for _, name := range sortedKeys(d.entries) {
+		before := d.snapshot(name, false)
+		after := d.snapshot(name, true)
+
+		var rels []string
+		for rel := range before {
+			rels = append(rels, rel)
+		}
+		for rel := range after {
+			if _, ok := before[rel]; !ok {
+				rels = append(rels, rel)
+			}
+		}
+		slices.Sort(rels)
+		for _, rel := range rels {
+			relPath, _ := filepath.Rel(d.root, filepath.Join(name, rel))
+			changes = append(changes, compareFileStates(filepath.ToSlash(relPath), before[rel], after[rel])...)
+		}
+	}
+	slices.SortStableFunc(changes, func(a fileChange, b fileChange) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return changes
+}
+
+// snapshot lists name and everything below it, either on disk or after the recorded changes. Paths overlaid by
+// their own entry are left out, they are compared separately
+func (d *dryRunFileSystem) snapshot(name string, overlaid bool) map[string]fileState {
+	states := map[string]fileState{}
+	realRoot := name
+	if overlaid {
+		var ok bool
+		realRoot, _, ok = d.resolve(name)
+		if !ok {
+			return states
+		}
+	}
+	filepath.WalkDir(realRoot, func(realPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(realRoot, realPath)
+		if _, ok := d.entries[filepath.Join(name, rel)]; ok && rel != "." {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		var info os.FileInfo
+		if overlaid {
+			info, err = d.Lstat(filepath.Join(name, rel))
+		} else {
+			info, err = os.Lstat(realPath)
+		}
+		if err == nil {
+			states[rel] = fileState{realPath: realPath, info: info}
+		}
+		return nil
+	})
+	return states
+}
+
+func compareFileStates(relPath string, before fileState, after fileState) []fileChange {
+	switch {
+	case before.info == nil && after.info == nil:
+		return nil
+	case before.info == nil:
+		return []fileChange{{Path: relPath, Change: ChangeAdded, IsDir: after.info.IsDir(), Size: fileSize(after.info), Mode: formatMode(after.info), Properties: propertyChanges(relPath, before, after)}}
+	case after.info == nil:
+		return []fileChange{{Path: relPath, Change: ChangeRemoved, IsDir: before.info.IsDir(), OldSize: fileSize(before.info), OldMode: formatMode(before.info)}}
+	case before.info.Mode().Type() != after.info.Mode().Type():
+		return append(compareFileStates(relPath, before, fileState{}), compareFileStates(relPath, fileState{}, after)...)
+	}
+
+	change := fileChange{
+		Path:    relPath,
+		IsDir:   after.info.IsDir(),
+		OldSize: fileSize(before.info),
+		Size:    fileSize(after.info),
+		OldMode: formatMode(before.info),
+		Mode:    formatMode(after.info),
+	}
+	if !after.info.IsDir() && !sameContent(before, after) {
+		change.Change = ChangeModified
+		change.Properties = propertyChanges(relPath, before, after)
+	} else if change.OldMode != change.Mode {
+		change.Change = ChangePermissions
+	} else {
+		return nil
+	}
+	return []fileChange{change}
+}
+
+func fileSize(info os.FileInfo) int64 {
+	if info.IsDir() {
+		return 0
+	}
+	return info.Size()
+}
+
+func formatMode(info os.FileInfo) string {
+	return fmt.Sprintf("%04o", info.Mode().Perm())
+}
+
+func sameContent(before fileState, after fileState) bool {
+	if before.info.Size() != after.info.Size() {
+		return false
+	}
+	if before.info.Mode().Type() == os.ModeSymlink {
+		beforeTarget, _ := os.Readlink(before.realPath)
+		afterTarget, _ := os.Readlink(after.realPath)
+		return beforeTarget == afterTarget
+	}
+	beforeData, err := os.ReadFile(before.realPath)
+	if err != nil {
+		return false
+	}
+	afterData, err := os.ReadFile(after.realPath)
+	return err == nil && bytes.Equal(beforeData, afterData)
+}
+
+// propertyChanges lists the keys that differ when relPath is the server.properties file
+func propertyChanges(relPath string, before fileState, after fileState) []propertyChange {
+	if relPath != "server.properties" {
+		return nil
+	}
+	load := func(state fileState) map[string]string {
+		if state.info == nil {
+			return map[string]string{}
+		}
+		data, err := os.ReadFile(state.realPath)
+		if err != nil {
+			return map[string]string{}
+		}
+		loaded, err := properties.Load(data, properties.UTF8)
+		if err != nil {
+			return map[string]string{}
+		}
+		return loaded.Map()
+	}
+	beforeValues, afterValues := load(before), load(after)
+	for _, values := range []map[string]string{beforeValues, afterValues} {
+		for key, value := range values {
+			if isSensitiveName(key) && value != "" {
+				values[key] = Redacted
+			}
+		}
+	}
+
+	var changes []propertyChange
+	for _, key := range sortedKeys(afterValues) {
+		if beforeValue, ok := beforeValues[key]; !ok || beforeValue != afterValues[key] {
+			changes = append(changes, propertyChange{Key: key, OldValue: beforeValue, Value: afterValues[key]})
+		}
+	}
+	for _, key := range sortedKeys(beforeValues) {
+		if _, ok := afterValues[key]; !ok {
+			changes = append(changes, propertyChange{Key: key, OldValue: beforeValues[key]})
+		}
+	}
+	return changes
+}
+
+// printDryRunReport writes the changes as text or JSON
+func printDryRunReport(w io.Writer, format string, report dryRunReport) {
+	if format == DryRunFormatJson {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(report); err != nil {
+			panic("Failed to write dry run report: " + err.Error())
+		}
+		return
+	}
+
+	fmt.Fprintf(w, "Dry run of %s, %d changes:\n", report.ServerFolder, len(report.Changes))
+	for _, change := range report.Changes {
+		name := change.Path
+		if change.IsDir {
+			name += "/"
+		}
+		switch change.Change {
+		case ChangeAdded:
+			if change.IsDir {
+				fmt.Fprintf(w, "  + %s (mode %s)\n", name, change.Mode)
+			} else {
+				fmt.Fprintf(w, "  + %s (%d bytes, mode %s)\n", name, change.Size, change.Mode)
+			}
+		case ChangeRemoved:
+			if change.IsDir {
+				fmt.Fprintf(w, "  - %s\n", name)
+			} else {
+				fmt.Fprintf(w, "  - %s (%d bytes)\n", name, change.OldSize)
+			}
+		case ChangeModified:
+			fmt.Fprintf(w, "  ~ %s (%d -> %d bytes)\n", name, change.OldSize, change.Size)
+		}
+		if change.OldMode != "" && change.Mode != "" && change.OldMode != change.Mode {
+			fmt.Fprintf(w, "  * %s mode %s -> %s\n", name, change.OldMode, change.Mode)
+		}
+		for _, property := range change.Properties {
+			fmt.Fprintf(w, "      %s: %q -> %q\n", property.Key, property.OldValue, property.Value)
+		}
+	}
+}
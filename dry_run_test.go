@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// snapshotTestDir lists every path below root with its mode and content
+func snapshotTestDir(t *testing.T, root string) map[string]string {
+	snapshot := map[string]string{}
+	err := filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		state := info.Mode().String()
+		if info.Mode().IsRegular() {
+			content, err := os.ReadFile(filePath)
+			if err != nil {
+				return err
+			}
+			state += " " + string(content)
+		}
+		snapshot[filePath] = state
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snapshot
+}
+
+func findChange(t *testing.T, changes []fileChange, relPath string, change string) fileChange {
+	t.Helper()
+	for _, fileChange := range changes {
+		if fileChange.Path == relPath && fileChange.Change == change {
+			return fileChange
+		}
+	}
+	t.Fatalf("Expected %s to be %s but got %v", relPath, change, changes)
+	return fileChange{}
+}
+
+func TestDryRunInit(t *testing.T) {
+	server := serveTestFiles(t, map[string]string{
+		"/pack.zip": writeTestZip(t, []testArchiveMember{
+			{Name: "Pack/startserver.sh", Content: "java -jar server.jar"},
+			{Name: "Pack/config/ducky.yml", Content: "ducks: 1\n"},
+			{Name: "Pack/world", Content: "flat"},
+		}),
+		"/goose.jar": writeTestFile(t, "honk"),
+	})
+	serverDir := t.TempDir()
+	for name, content := range map[string]string{
+		"startserver.sh":    "java -jar server.jar",
+		"server.properties": "max-players=20\nmotd=Ducky\n",
+		"world/level.dat":   "level",
+	} {
+		if err := os.MkdirAll(path.Dir(path.Join(serverDir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path.Join(serverDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := snapshotTestDir(t, serverDir)
+
+	filesInit := filesInitDefault
+	filesInit.ConfigPatches = []configPatch{expectValid(t, validateConfigPatch, configPatch{Path: "config/ducky.yml", Set: map[string]any{"ducks": 10}})}
+	report := dryRunInit(envs{
+		filesInit: filesInit,
+		sources: []deploymentSource{
+			expectValid(t, validateDeploymentSource, deploymentSource{Type: DeploymentTypeZip, Url: server.URL + "/pack.zip"}),
+			expectValid(t, validateDeploymentSource, deploymentSource{Type: DeploymentTypeFile, Url: server.URL + "/goose.jar", Target: "mods"}),
+		},
+		startScriptName: "startserver.sh",
+	}, serverDir)
+
+	after := snapshotTestDir(t, serverDir)
+	if len(before) != len(after) {
+		t.Fatalf("Expected the dry run to leave the server folder untouched but got %v", after)
+	}
+	for filePath, state := range before {
+		if after[filePath] != state {
+			t.Fatalf("Expected the dry run to leave %s untouched", filePath)
+		}
+	}
+
+	if change := findChange(t, report.Changes, "mods/goose.jar", ChangeAdded); change.Size != 4 {
+		t.Fatalf("Expected goose.jar to be 4 bytes but got %d", change.Size)
+	}
+	if change := findChange(t, report.Changes, "config/ducky.yml", ChangeAdded); change.Size != int64(len("ducks: 10\n")) {
+		t.Fatalf("Expected the patched ducky.yml to be reported but got %d bytes", change.Size)
+	}
+	// The start script is also given a shebang
+	if change := findChange(t, report.Changes, "startserver.sh", ChangeModified); change.OldMode != "0644" || change.Mode != "0755" {
+		t.Fatalf("Expected startserver.sh to become executable but got %s -> %s", change.OldMode, change.Mode)
+	}
+	findChange(t, report.Changes, "world/level.dat", ChangeRemoved)
+	findChange(t, report.Changes, "world", ChangeAdded)
+	findChange(t, report.Changes, "eula.txt", ChangeAdded)
+
+	properties := findChange(t, report.Changes, "server.properties", ChangeModified).Properties
+	changedKeys := map[string]propertyChange{}
+	for _, property := range properties {
+		changedKeys[property.Key] = property
+	}
+	if changedKeys["max-players"].OldValue != "20" || changedKeys["max-players"].Value != "60" {
+		t.Fatalf("Expected max-players to change from 20 to 60 but got %v", changedKeys["max-players"])
+	}
+
+	var text, jsonReport bytes.Buffer
+	printDryRunReport(&text, DryRunFormatText, report)
+	if !strings.Contains(text.String(), "+ mods/goose.jar (4 bytes") {
+		t.Fatalf("Expected the text report to list goose.jar but got:\n%s", text.String())
+	}
+	printDryRunReport(&jsonReport, DryRunFormatJson, report)
+	var decoded dryRunReport
+	if err := json.Unmarshal(jsonReport.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Changes) != len(report.Changes) {
+		t.Fatalf("Expected %d changes in the JSON report but got %d", len(report.Changes), len(decoded.Changes))
+	}
+}
+
+func TestDryRunReadDir(t *testing.T) {
+	serverDir := writeTestServerFiles(t, map[string]string{"server.jar": "jar", "old.jar": "jar"})
+	dryRun := newDryRunFileSystem(serverDir)
+	defer dryRun.Close()
+
+	if err := dryRun.WriteFile(path.Join(serverDir, "fabric-server-launch.jar"), []byte("jar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := dryRun.RemoveAll(path.Join(serverDir, "old.jar")); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := dryRun.ReadDir(serverDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if strings.Join(names, ",") != "fabric-server-launch.jar,server.jar" {
+		t.Fatalf("Expected the overlaid folder listing but got %v", names)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// extraFile is a single file written into the server folder after the deployment sources are applied, exactly one
+// of Url, Content and Base64 provides its content
+type extraFile struct {
+	// Path is relative to the server folder
+	Path string
+	Url  string
+	// Mirrors are tried in order when Url fails, they must serve the same content
+	Mirrors []string
+	// Auth optionally authenticates the requests for Url and Mirrors
+	Auth *downloadAuth
+	// Hash optionally verifies a downloaded file, formatted as algorithm:hex, for example sha256:9f86d0...
+	Hash    string
+	Content string
+	Base64  string
+	// Mode is an octal permission string, defaults to 0644
+	Mode string
+	// Overwrite decides what happens when the file already exists, defaults to OVERWRITE
+	Overwrite string
+
+	mode os.FileMode
+}
+
+// validateExtraFile checks an extra file and fills in its defaults, name identifies the file in errors
+func validateExtraFile(name fieldNamer, file extraFile, problems *validationErrors) extraFile {
+	path := cleanArchiveMemberName(file.Path)
+	if file.Path == "" || path == "." || isEscapingPath(path) {
+		problems.add(name("Path"), "invalid path "+strconv.Quote(file.Path))
+	}
+	file.Path = path
+
+	sources := 0
+	for _, value := range []string{file.Url, file.Content, file.Base64} {
+		if value != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		problems.add(name("Url"), "needs exactly one of Url, Content and Base64")
+	}
+	if file.Url != "" {
+		if _, err := parseSourceURL(file.Url, DownloadSchemes); err != nil {
+			problems.add(name("Url"), "invalid url "+strconv.Quote(redactString(file.Url))+": "+err.Error())
+		}
+	}
+	for _, mirror := range file.Mirrors {
+		if _, err := parseSourceURL(mirror, DownloadSchemes); err != nil {
+			problems.add(name("Mirrors"), "invalid mirror url "+strconv.Quote(redactString(mirror))+": "+err.Error())
+		}
+	}
+	if file.Url == "" && len(file.Mirrors) > 0 {
+		problems.add(name("Mirrors"), "mirrors need a url")
+	}
+	if file.Url == "" && file.Auth != nil {
+		problems.add(name("Auth"), "auth needs a url")
+	}
+	file.Auth = validateDownloadAuth(fieldPrefix(name("Auth")), file.Auth, problems)
+	if file.Url == "" && file.Hash != "" {
+		problems.add(name("Hash"), "a hash needs a url")
+	}
+	if file.Hash != "" {
+		if _, _, err := parseHash(file.Hash); err != nil {
+			problems.add(name("Hash"), "invalid hash: "+err.Error())
+		}
+	}
+	if file.Base64 != "" {
+		if _, err := base64.StdEncoding.DecodeString(file.Base64); err != nil {
+			problems.add(name("Base64"), "invalid base64 content: "+err.Error())
+		}
+	}
+
+	file.mode = 0644
+	if file.Mode != "" {
+		mode, err := parseMode(file.Mode)
+		if err != nil {
+			problems.add(name("Mode"), err.Error())
+		}
+		file.mode = mode
+	}
+
+	if file.Overwrite == "" {
+		file.Overwrite = ConflictPolicyOverwrite
+	}
+	if !checkStringMatches(file.Overwrite, ConflictPolicies) {
+		problems.add(name("Overwrite"), "invalid overwrite policy "+strconv.Quote(file.Overwrite)+", expected one of "+strings.Join(ConflictPolicies, ", "))
+	}
+	return file
+}
+
+func writeExtraFiles(files []extraFile, serverFolderPath string) {
+	if len(files) == 0 {
+		return
+	}
+	slog.Info(fmt.Sprintf("Writing %d extra files...", len(files)))
+	for _, file := range files {
+		writeExtraFile(file, serverFolderPath)
+	}
+	slog.Info("Successfully written extra files")
+}
+
+func writeExtraFile(file extraFile, serverFolderPath string) {
+	filePath, err := resolveArchiveMemberPath(serverFolderPath, file.Path)
+	if err != nil {
+		panic("Refusing to write extra file: " + err.Error())
+	}
+
+	_, err = ServerFiles.Lstat(filePath)
+	if err == nil {
+		switch file.Overwrite {
+		case ConflictPolicySkip:
+			slog.Info("Extra file " + filePath + " already exists, keeping it")
+			return
+		case ConflictPolicyError:
+			panic("Extra file " + filePath + " already exists")
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		panic("Failed to check extra file " + filePath + ": " + err.Error())
+	}
+
+	if err := ServerFiles.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+		panic("Failed to create directory: " + err.Error())
+	}
+	// Write next to the destination and rename, so a failed download never leaves a broken file behind
+	tmpFile, err := ServerFiles.CreateTemp(filepath.Dir(filePath), ".omsms-extra-*")
+	if err != nil {
+		panic("Failed to create temporary file: " + err.Error())
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	switch {
+	case file.Url != "":
+		slog.Info("Downloading extra file " + file.Path + " from " + file.Url)
+		downloadFile(append([]string{file.Url}, file.Mirrors...), file.Hash, file.Auth, tmpFile)
+	case file.Content != "":
+		_, err = tmpFile.WriteString(file.Content)
+	default:
+		var content []byte
+		content, err = base64.StdEncoding.DecodeString(file.Base64)
+		if err == nil {
+			_, err = tmpFile.Write(content)
+		}
+	}
+	if err != nil {
+		panic("Failed to write extra file " + file.Path + ": " + err.Error())
+	}
+
+	if err := tmpFile.Chmod(file.mode); err != nil {
+		panic("Failed to change file permission for extra file " + file.Path + ": " + err.Error())
+	}
+	if err := ServerFiles.RemoveAll(filePath); err != nil {
+		panic("Failed to replace existing file " + filePath + ": " + err.Error())
+	}
+	if err := ServerFiles.Rename(tmpFile.Name(), filePath); err != nil {
+		panic("Failed to move extra file into place " + filePath + ": " + err.Error())
+	}
+	slog.Info("Successfully written extra file " + filePath)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestWriteExtraFiles(t *testing.T) {
+	server := serveTestFiles(t, map[string]string{"/goose.jar": writeTestFile(t, "honk")})
+	digest := sha256.Sum256([]byte("honk"))
+	serverDir := t.TempDir()
+	if err := os.WriteFile(path.Join(serverDir, "ops.json"), []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	files := []extraFile{
+		{Path: "mods/goose.jar", Url: server.URL + "/goose.jar", Hash: "sha256:" + hex.EncodeToString(digest[:])},
+		{Path: "config/ducky.toml", Content: "loud=true"},
+		{Path: "/scripts/quack.sh", Base64: base64.StdEncoding.EncodeToString([]byte("echo quack")), Mode: "0755"},
+		{Path: "ops.json", Content: `[{"name":"ducky"}]`, Overwrite: ConflictPolicySkip},
+	}
+	for i, file := range files {
+		files[i] = expectValid(t, validateExtraFile, file)
+	}
+	writeExtraFiles(files, serverDir)
+
+	expectFileContent(t, path.Join(serverDir, "mods", "goose.jar"), "honk")
+	expectFileContent(t, path.Join(serverDir, "config", "ducky.toml"), "loud=true")
+	expectFileContent(t, path.Join(serverDir, "scripts", "quack.sh"), "echo quack")
+	expectFileContent(t, path.Join(serverDir, "ops.json"), "[]")
+	info, err := os.Stat(path.Join(serverDir, "scripts", "quack.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Fatalf("Expected quack.sh to have mode 0755 but got %v", info.Mode().Perm())
+	}
+}
+
+func TestWriteExtraFileWithHashMismatch(t *testing.T) {
+	server := serveTestFiles(t, map[string]string{"/goose.jar": writeTestFile(t, "honk")})
+	serverDir := t.TempDir()
+	file := expectValid(t, validateExtraFile, extraFile{
+		Path: "mods/goose.jar",
+		Url:  server.URL + "/goose.jar",
+		Hash: "sha1:0000000000000000000000000000000000000000",
+	})
+
+	expectPanic(t, func() { writeExtraFile(file, serverDir) })
+	if _, err := os.Stat(path.Join(serverDir, "mods", "goose.jar")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("Expected goose.jar to not be written when the hash does not match")
+	}
+}
+
+func TestValidateExtraFileWithInvalidEntries(t *testing.T) {
+	invalidFiles := map[string]extraFile{
+		"Path":      {Path: "../../etc/passwd", Content: "ducky"},
+		"Url":       {Path: "config/ducky.toml"},
+		"Base64":    {Path: "config/ducky.toml", Base64: "not base64!"},
+		"Mode":      {Path: "config/ducky.toml", Content: "loud=true", Mode: "rwx"},
+		"Hash":      {Path: "mods/goose.jar", Url: "https://ducky.com/goose.jar", Hash: "crc32:1234"},
+		"Overwrite": {Path: "config/ducky.toml", Content: "loud=true", Overwrite: "MAYBE"},
+	}
+	for field, file := range invalidFiles {
+		expectInvalid(t, validateExtraFile, file, field)
+	}
+	expectInvalid(t, validateExtraFile, extraFile{Path: "config/ducky.toml", Content: "loud=true", Base64: "bG91ZD10cnVl"}, "Url")
+}
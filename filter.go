@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// fileFilter decides which deployed files are kept, the zero value keeps everything
+type fileFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// newFileFilter compiles include and exclude glob lists. Globs support *, ?, [...] and ** for any number of
+// directories, a glob without a slash matches a file or directory name at any depth. A path matches when the glob
+// matches the path itself or one of its parent directories, so "world" also matches everything beneath world/
+func newFileFilter(include []string, exclude []string) (fileFilter, error) {
+	includeGlobs, err := compileGlobs(include)
+	if err != nil {
+		return fileFilter{}, err
+	}
+	excludeGlobs, err := compileGlobs(exclude)
+	if err != nil {
+		return fileFilter{}, err
+	}
+	return fileFilter{include: includeGlobs, exclude: excludeGlobs}, nil
+}
+
+// compileGlobs compiles every pattern with compileGlob and stops at the first invalid one
+func compileGlobs(patterns []string) ([]*regexp.Regexp, error) {
+	var globs []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := compileGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		globs = append(globs, re)
+	}
+	return globs, nil
+}
+
+// compileGlob translates a glob into an anchored regular expression
+func compileGlob(pattern string) (*regexp.Regexp, error) {
+	glob := strings.TrimSuffix(strings.TrimSpace(pattern), "/")
+	if glob == "" {
+		return nil, errors.New("empty glob pattern")
+	}
+	if strings.HasPrefix(glob, "/") {
+		glob = glob[1:]
+	} else if !strings.Contains(glob, "/") {
+		glob = "**/" + glob
+	}
+
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**/") {
+				re.WriteString("(?:.*/)?")
+				i += 2
+			} else if strings.HasPrefix(glob[i:], "**") {
+				re.WriteString(".*")
+				i++
+			} else {
+				re.WriteString("[^/]*")
+			}
+		case '?':
+			re.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end == -1 {
+				return nil, errors.New("unterminated character class in glob pattern: " + pattern)
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end
+		case '/':
+			if glob[i:] == "/**" {
+				re.WriteString("(?:/.*)?")
+				i += 2
+			} else {
+				re.WriteString("/")
+			}
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+
+	compiled, err := regexp.Compile(re.String())
+	if err != nil {
+		return nil, errors.New("invalid glob pattern " + pattern + ": " + err.Error())
+	}
+	return compiled, nil
+}
+
+// matchesPathOrParent reports whether any of the expressions matches name or one of its parent directories
+func matchesPathOrParent(expressions []*regexp.Regexp, name string) bool {
+	for candidate := name; candidate != "." && candidate != ""; {
+		for _, re := range expressions {
+			if re.MatchString(candidate) {
+				return true
+			}
+		}
+		slash := strings.LastIndexByte(candidate, '/')
+		if slash == -1 {
+			break
+		}
+		candidate = candidate[:slash]
+	}
+	return false
+}
+
+// allows reports whether the slash separated path relative to the server root passes the filter
+func (filter fileFilter) allows(name string) bool {
+	name = cleanArchiveMemberName(name)
+	if len(filter.include) > 0 && !matchesPathOrParent(filter.include, name) {
+		return false
+	}
+	return !matchesPathOrParent(filter.exclude, name)
+}
+
+func (filter fileFilter) isEmpty() bool {
+	return len(filter.include) == 0 && len(filter.exclude) == 0
+}
+
+// applyFileFilter removes files under root that do not pass the filter, used for deployments that cannot be
+// filtered while they are written such as git checkouts. The .git directory is always kept
+func applyFileFilter(root string, filter fileFilter) {
+	if filter.isEmpty() {
+		return
+	}
+
+	filtered := 0
+	err := filepath.WalkDir(root, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, filePath)
+		if err != nil || rel == "." {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if name == ".git" {
+			return filepath.SkipDir
+		}
+
+		if d.IsDir() {
+			// Directories missing from the include list may still contain included files, so only excludes remove them
+			if matchesPathOrParent(filter.exclude, name) {
+				slog.Debug("Filtered out directory " + name)
+				filtered++
+				if err := os.RemoveAll(filePath); err != nil {
+					return err
+				}
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !filter.allows(name) {
+			slog.Debug("Filtered out file " + name)
+			filtered++
+			return os.Remove(filePath)
+		}
+		return nil
+	})
+	if err != nil {
+		panic("Failed to apply file filter to " + root + ": " + err.Error())
+	}
+	slog.Info(fmt.Sprintf("Filtered out %d paths from %s", filtered, root))
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"archive/tar"
+	"errors"
+	"os"
+	"path"
+	"testing"
+)
+
+func expectAllowed(t *testing.T, filter fileFilter, name string) {
+	if !filter.allows(name) {
+		t.Fatalf("Expected %s to pass the filter", name)
+	}
+}
+
+func expectFiltered(t *testing.T, filter fileFilter, name string) {
+	if filter.allows(name) {
+		t.Fatalf("Expected %s to be filtered out", name)
+	}
+}
+
+func mustFileFilter(t *testing.T, include []string, exclude []string) fileFilter {
+	filter, err := newFileFilter(include, exclude)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filter
+}
+
+func TestFileFilterExclude(t *testing.T) {
+	filter := mustFileFilter(t, nil, []string{"*.bat", "README*", "resourcepacks/", "/world", "config/**/*.client.toml"})
+
+	expectFiltered(t, filter, "startserver.bat")
+	expectFiltered(t, filter, "scripts/install.bat")
+	expectFiltered(t, filter, "README.md")
+	expectFiltered(t, filter, "resourcepacks")
+	expectFiltered(t, filter, "resourcepacks/faithful.zip")
+	expectFiltered(t, filter, "world/level.dat")
+	expectFiltered(t, filter, "config/ducky.client.toml")
+	expectFiltered(t, filter, "config/ducky/deep/ducky.client.toml")
+	expectAllowed(t, filter, "startserver.sh")
+	expectAllowed(t, filter, "mods/world/ducky.jar")
+	expectAllowed(t, filter, "config/ducky.server.toml")
+}
+
+func TestFileFilterInclude(t *testing.T) {
+	filter := mustFileFilter(t, []string{"mods/**", "config", "*.sh"}, []string{"mods/**/optifine*.jar"})
+
+	expectAllowed(t, filter, "mods")
+	expectAllowed(t, filter, "mods/ducky.jar")
+	expectAllowed(t, filter, "config/ducky/ducky.toml")
+	expectAllowed(t, filter, "startserver.sh")
+	expectAllowed(t, filter, "scripts/setup.sh")
+	expectFiltered(t, filter, "mods/client/optifine-1.2.jar")
+	expectFiltered(t, filter, "server.properties")
+	expectFiltered(t, filter, "shaderpacks/bsl.zip")
+}
+
+func TestFileFilterInvalidGlob(t *testing.T) {
+	if _, err := newFileFilter([]string{"[mods"}, nil); err == nil {
+		t.Fatal("Expected unterminated character class to be rejected")
+	}
+}
+
+func TestExtractTarArchiveWithFilteredHardLinkTarget(t *testing.T) {
+	archivePath := writeTestTar(t, ArchiveFormatTar, []testArchiveMember{
+		{Name: "docs/ducky.md", Content: "quack"},
+		{Name: "mods/ducky.md", Typeflag: tar.TypeLink, Linkname: "docs/ducky.md"},
+		{Name: "mods/ducky.jar", Content: "quack"},
+	})
+	serverDir := t.TempDir()
+
+	extractArchive(archivePath, serverDir, archiveOptions{}, mustFileFilter(t, nil, []string{"docs"}))
+
+	expectFileContent(t, path.Join(serverDir, "mods", "ducky.jar"), "quack")
+	if _, err := os.Lstat(path.Join(serverDir, "mods", "ducky.md")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected the hard link to the filtered out file to be skipped but got %v", err)
+	}
+}
+
+func TestValidateDeploymentSourceWithInvalidExclude(t *testing.T) {
+	expectInvalid(t, validateDeploymentSource, deploymentSource{Type: DeploymentTypeZip, Url: "https://ducky.com/pack.zip", Include: []string{"mods"}, Exclude: []string{"[mods"}}, "Exclude")
+	expectInvalid(t, validateDeploymentSource, deploymentSource{Type: DeploymentTypeZip, Url: "https://ducky.com/pack.zip", Include: []string{"[mods"}, Exclude: []string{"*.bat"}}, "Include")
+}
+
+func TestReadEnvsWithInvalidExcludeFiles(t *testing.T) {
+	t.Setenv("OMSMS_SERVER_FILES_INIT", "{}")
+	t.Setenv("OMSMS_SERVER_DEPLOYMENT_TYPE", DeploymentTypeZip)
+	t.Setenv("OMSMS_SERVER_DEPLOYMENT_VALUE", "https://ducky.com/pack.zip")
+	t.Setenv("OMSMS_SERVER_EXCLUDE_FILES", "[mods")
+
+	_, err := readEnvs()
+	problems, _ := err.(validationErrors)
+	expectProblem(t, problems, "OMSMS_SERVER_EXCLUDE_FILES")
+}
+
+func TestExtractArchiveWithFileFilter(t *testing.T) {
+	archivePath := writeTestZip(t, []testArchiveMember{
+		{Name: "pack/mods/ducky.jar", Content: "quack"},
+		{Name: "pack/startserver.bat", Content: "java -jar server.jar"},
+		{Name: "pack/shaderpacks/bsl.zip", Content: "shiny"},
+	})
+	serverDir := t.TempDir()
+
+	extractArchive(archivePath, serverDir, archiveOptionsDefault, mustFileFilter(t, nil, []string{"*.bat", "shaderpacks"}))
+
+	expectFileContent(t, path.Join(serverDir, "mods", "ducky.jar"), "quack")
+	for _, name := range []string{"startserver.bat", "shaderpacks"} {
+		if _, err := os.Stat(path.Join(serverDir, name)); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("Expected %s to be filtered out", name)
+		}
+	}
+}
+
+func TestApplyFileFilter(t *testing.T) {
+	serverDir := t.TempDir()
+	for _, name := range []string{".git/HEAD", "mods/ducky.jar", "world/level.dat", "README.md"} {
+		if err := os.MkdirAll(path.Dir(path.Join(serverDir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path.Join(serverDir, name), []byte("quack"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	applyFileFilter(serverDir, mustFileFilter(t, nil, []string{"world", "README*", "HEAD"}))
+
+	expectFileContent(t, path.Join(serverDir, ".git", "HEAD"), "quack")
+	expectFileContent(t, path.Join(serverDir, "mods", "ducky.jar"), "quack")
+	for _, name := range []string{"world", "README.md"} {
+		if _, err := os.Stat(path.Join(serverDir, name)); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("Expected %s to be filtered out", name)
+		}
+	}
+}
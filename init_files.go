@@ -2,16 +2,21 @@ package main
 
 import (
 	"fmt"
-	"io"
 	"log/slog"
-	"net/http"
-	"os"
+	"math"
 	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf16"
 )
 
 type filesInit struct {
 	CustomStartScript string
 	ServerIconUrl     string
+	ServerIconMirrors []string
+	ExtraFiles        []extraFile
+	ConfigPatches     []configPatch
 
 	// server.properties stuff
 	Motd               string
@@ -23,30 +28,168 @@ type filesInit struct {
 	SpawnProtection    uint
 	ViewDistance       uint
 	SimulationDistance uint
+
+	// RCON and query, a missing RCON password is generated and written to RconPasswordPath
+	EnableRcon       bool
+	RconPort         uint
+	RconPassword     string
+	RconPasswordPath string
+	EnableQuery      bool
+	QueryPort        uint
+
+	// JVM arguments sized from the memory limit, written to JvmArgsFile when it is set
+	JvmArgsFile    string
+	HeapPercent    uint
+	HeapReserveMb  uint
+	JvmFlagsPreset string
+	JvmExtraArgs   []string
+
+	// Shell scripts matching ScriptPatterns and the start script get LF line endings, a shebang and the exec bit
+	SanitiseScripts bool
+	ScriptPatterns  []string
+
+	// Files matching TemplatePatterns are rendered with text/template like CustomStartScript
+	TemplatePatterns []string
+
+	// Ownership and permissions of the server folder for a game container running as another user, unset keeps the
+	// owner so a payload that leaves them at zero never hands the folder to root
+	OwnerUid             *int64
+	OwnerGid             *int64
+	NormalisePermissions bool
+	DirMode              string
+	FileMode             string
+	ExecutablePatterns   []string
 }
 
 var filesInitDefault = filesInit{
-	Motd:               `An \u00A7cOMSMS\u00A7r managed server, more info at \u00A79\u00A7nomsms.octsrv.org`,
-	EnableCommandBlock: true,
-	OnlineMode:         true,
-	AllowFlight:        false,
-	MaxTickTime:        -1,
-	MaxPlayers:         60,
-	SpawnProtection:    0,
-	ViewDistance:       10,
-	SimulationDistance: 9,
+	Motd:                 `An \u00A7cOMSMS\u00A7r managed server, more info at \u00A79\u00A7nomsms.octsrv.org`,
+	EnableCommandBlock:   true,
+	OnlineMode:           true,
+	AllowFlight:          false,
+	MaxTickTime:          -1,
+	MaxPlayers:           60,
+	SpawnProtection:      0,
+	ViewDistance:         10,
+	SimulationDistance:   9,
+	EnableRcon:           false,
+	RconPort:             25575,
+	RconPasswordPath:     ".omsms/rcon-password",
+	EnableQuery:          false,
+	QueryPort:            25565,
+	JvmArgsFile:          "",
+	HeapPercent:          80,
+	HeapReserveMb:        1024,
+	JvmFlagsPreset:       JvmFlagsPresetNone,
+	SanitiseScripts:      true,
+	NormalisePermissions: false,
+	DirMode:              "0755",
+	FileMode:             "0644",
 }
 
-func initServerFiles(filesInit filesInit, startScriptName string, serverFolderPath string) {
-	slog.Info("Initialising server files...")
+// filesInitRanges are the inclusive bounds of the numeric server.properties settings
+var filesInitRanges = map[string]valueRange{
+	"MaxTickTime":        {Min: -1, Max: math.MaxInt64},
+	"MaxPlayers":         {Min: 0, Max: math.MaxInt32},
+	"SpawnProtection":    {Min: 0, Max: math.MaxInt32},
+	"ViewDistance":       {Min: 3, Max: 32},
+	"SimulationDistance": {Min: 3, Max: 32},
+	"RconPort":           {Min: 1, Max: 65535},
+	"QueryPort":          {Min: 1, Max: 65535},
+	"HeapPercent":        {Min: 1, Max: 100},
+	"HeapReserveMb":      {Min: 0, Max: math.MaxInt32},
+	"OwnerUid":           {Min: -1, Max: math.MaxInt32},
+	"OwnerGid":           {Min: -1, Max: math.MaxInt32},
+}
 
-	eulaTxt, err := os.OpenFile(path.Join(serverFolderPath, "eula.txt"), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
-	if err != nil {
-		panic("Failed to open eula.txt: " + err.Error())
+// filesInitRangeConditions are the settings whose range only matters while the feature using them is enabled, a
+// payload leaving a disabled feature at its zero value stays valid
+var filesInitRangeConditions = map[string]func(filesInit) bool{
+	"RconPort":  func(filesInit filesInit) bool { return filesInit.EnableRcon },
+	"QueryPort": func(filesInit filesInit) bool { return filesInit.EnableQuery },
+	// The heap is only sized when JVM arguments are written
+	"HeapPercent":   func(filesInit filesInit) bool { return filesInit.JvmArgsFile != "" },
+	"HeapReserveMb": func(filesInit filesInit) bool { return filesInit.JvmArgsFile != "" },
+}
+
+// validateFilesInit checks the files init settings against the ranges the server accepts, name maps its fields to the
+// reported paths
+func validateFilesInit(name fieldNamer, filesInit filesInit, problems *validationErrors) filesInit {
+	if filesInit.ServerIconUrl != "" {
+		if _, err := parseSourceURL(filesInit.ServerIconUrl, DownloadSchemes); err != nil {
+			problems.add(name("ServerIconUrl"), "invalid url "+strconv.Quote(redactString(filesInit.ServerIconUrl))+": "+err.Error())
+		}
+	}
+	for _, mirror := range filesInit.ServerIconMirrors {
+		if _, err := parseSourceURL(mirror, DownloadSchemes); err != nil {
+			problems.add(name("ServerIconMirrors"), "invalid mirror url "+strconv.Quote(redactString(mirror))+": "+err.Error())
+		}
+	}
+	if filesInit.ServerIconUrl == "" && len(filesInit.ServerIconMirrors) > 0 {
+		problems.add(name("ServerIconMirrors"), "mirrors need a url")
+	}
+	if filesInit.EnableRcon {
+		passwordPath := cleanArchiveMemberName(filesInit.RconPasswordPath)
+		if filesInit.RconPasswordPath == "" || passwordPath == "." || !path.IsAbs(filesInit.RconPasswordPath) && isEscapingPath(passwordPath) {
+			problems.add(name("RconPasswordPath"), "invalid path "+strconv.Quote(filesInit.RconPasswordPath))
+		}
+		if strings.ContainsAny(filesInit.RconPassword, "\r\n") {
+			problems.add(name("RconPassword"), "must be a single line")
+		}
+	}
+	if filesInit.JvmArgsFile != "" {
+		argsPath := cleanArchiveMemberName(filesInit.JvmArgsFile)
+		if argsPath == "." || isEscapingPath(argsPath) {
+			problems.add(name("JvmArgsFile"), "invalid path "+strconv.Quote(filesInit.JvmArgsFile))
+		}
+		filesInit.JvmArgsFile = argsPath
+	}
+	filesInit.JvmFlagsPreset = strings.ToUpper(filesInit.JvmFlagsPreset)
+	if filesInit.JvmFlagsPreset == "" {
+		filesInit.JvmFlagsPreset = JvmFlagsPresetNone
+	}
+	if !checkStringMatches(filesInit.JvmFlagsPreset, JvmFlagsPresets) {
+		problems.add(name("JvmFlagsPreset"), "invalid preset "+strconv.Quote(filesInit.JvmFlagsPreset)+", expected one of "+strings.Join(JvmFlagsPresets, ", "))
+	}
+	if _, err := newFileFilter(filesInit.scriptPatterns(), nil); err != nil {
+		problems.add(name("ScriptPatterns"), "invalid pattern: "+err.Error())
+	}
+	if _, err := newFileFilter(filesInit.TemplatePatterns, nil); err != nil {
+		problems.add(name("TemplatePatterns"), "invalid pattern: "+err.Error())
+	}
+	if filesInit.NormalisePermissions {
+		for _, field := range []string{"DirMode", "FileMode"} {
+			if _, err := parseMode(reflect.ValueOf(filesInit).FieldByName(field).String()); err != nil {
+				problems.add(name(field), err.Error())
+			}
+		}
+	}
+	if _, err := newFileFilter(filesInit.ExecutablePatterns, nil); err != nil {
+		problems.add(name("ExecutablePatterns"), "invalid pattern: "+err.Error())
+	}
+	if _, err := parseTemplate("CustomStartScript", filesInit.CustomStartScript); err != nil {
+		problems.add(name("CustomStartScript"), "invalid template: "+err.Error())
 	}
-	defer eulaTxt.Close()
+	settings := reflect.ValueOf(filesInit)
+	for _, field := range sortedKeys(filesInitRanges) {
+		if enabled, ok := filesInitRangeConditions[field]; ok && !enabled(filesInit) {
+			continue
+		}
+		checkRange(name(field), settings.FieldByName(field), filesInitRanges[field], problems)
+	}
+
+	for i, file := range filesInit.ExtraFiles {
+		filesInit.ExtraFiles[i] = validateExtraFile(fieldPrefix(fmt.Sprintf("%s[%d]", name("ExtraFiles"), i)), file, problems)
+	}
+	for i, patch := range filesInit.ConfigPatches {
+		filesInit.ConfigPatches[i] = validateConfigPatch(fieldPrefix(fmt.Sprintf("%s[%d]", name("ConfigPatches"), i)), patch, problems)
+	}
+	return filesInit
+}
+
+func initServerFiles(filesInit filesInit, startScriptName string, serverFolderPath string) {
+	slog.Info("Initialising server files...")
 
-	_, err = eulaTxt.WriteAt([]byte("eula=true"), 0)
+	err := ServerFiles.WriteFile(path.Join(serverFolderPath, "eula.txt"), []byte("eula=true"), 0644)
 	if err != nil {
 		panic("Failed to write eula.txt: " + err.Error())
 	}
@@ -54,38 +197,32 @@ func initServerFiles(filesInit filesInit, startScriptName string, serverFolderPa
 
 	if filesInit.CustomStartScript != "" {
 		startScriptPath := path.Join(serverFolderPath, startScriptName)
-		slog.Info("Found custom script in config, writing custom start script " + startScriptPath + " with content: \n" + filesInit.CustomStartScript)
-
-		startScriptFile, err := os.OpenFile(startScriptPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+		customStartScript, err := renderTemplate(startScriptName, filesInit.CustomStartScript, newTemplateData(filesInit, serverFolderPath))
 		if err != nil {
-			panic("Failed to open destination file: " + startScriptPath + ", error: " + err.Error())
+			panic("Failed to render custom start script: " + err.Error())
 		}
-		defer startScriptFile.Close()
+		// The rendered script is as secret as the template it comes from
+		registerSecret(customStartScript)
+		slog.Info("Found custom script in config, writing custom start script " + startScriptPath + " with content: \n" + customStartScript)
 
-		_, err = startScriptFile.WriteAt([]byte(filesInit.CustomStartScript), 0)
+		err = ServerFiles.WriteFile(startScriptPath, []byte(customStartScript), 0755)
 		if err != nil {
 			panic("Failed to write script file: " + startScriptPath + ", error: " + err.Error())
 		}
+		// An existing start script keeps its mode when written
+		if err := ServerFiles.Chmod(startScriptPath, 0755); err != nil {
+			panic("Failed to change file permission for start script: " + startScriptPath + ", error: " + err.Error())
+		}
 		slog.Info("Successfully written custom start script in server folder")
 	}
 
 	if filesInit.ServerIconUrl != "" {
 		slog.Info("Found server icon url in config, downloading server icon: " + filesInit.ServerIconUrl)
-		resp, err := http.Get(filesInit.ServerIconUrl)
-		if err != nil {
-			panic("Failed to download server icon from " + filesInit.ServerIconUrl + ", error: " + err.Error())
-		}
-		defer resp.Body.Close()
+		icon := downloadBytes(append([]string{filesInit.ServerIconUrl}, filesInit.ServerIconMirrors...))
 		slog.Info("Successfully downloaded server icon from " + filesInit.ServerIconUrl + ", saving to server folder")
 
 		iconPath := path.Join(serverFolderPath, "server-icon.png")
-		iconFile, err := os.OpenFile(iconPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
-		if err != nil {
-			panic("Failed to open destination file: " + iconPath + ", error: " + err.Error())
-		}
-		defer iconFile.Close()
-
-		_, err = io.Copy(iconFile, resp.Body)
+		err = ServerFiles.WriteFile(iconPath, icon, 0644)
 		if err != nil {
 			panic("Failed to save icon file: " + iconPath + ", error: " + err.Error())
 		}
@@ -103,26 +240,49 @@ func initServerFiles(filesInit filesInit, startScriptName string, serverFolderPa
 		"spawn-protection":     filesInit.SpawnProtection,
 		"view-distance":        filesInit.ViewDistance,
 		"simulation-distance":  filesInit.SimulationDistance,
+		"enable-rcon":          filesInit.EnableRcon,
+		"enable-query":         filesInit.EnableQuery,
 	}
-
-	file, err := os.Create(path.Join(serverFolderPath, "server.properties"))
-	if err != nil {
-		panic("Error creating server.properties: " + err.Error())
+	if filesInit.EnableRcon {
+		properties["rcon.port"] = filesInit.RconPort
+		properties["rcon.password"] = escapePropertiesValue(rconPassword(filesInit, serverFolderPath))
+	}
+	if filesInit.EnableQuery {
+		properties["query.port"] = filesInit.QueryPort
 	}
-	defer file.Close()
-
-	slog.Info("Created server.properties, writing content")
 
-	_, err = file.Seek(0, 0)
+	var content strings.Builder
+	for key, value := range properties {
+		content.WriteString(fmt.Sprintf("%s=%v\n", key, value))
+	}
+	err = ServerFiles.WriteFile(path.Join(serverFolderPath, "server.properties"), []byte(content.String()), 0666)
 	if err != nil {
-		panic("Failed to reset server properties file write pointer to start: " + err.Error())
+		panic("Error writing server.properties: " + err.Error())
 	}
-	for key, value := range properties {
-		_, err := file.WriteString(fmt.Sprintf("%s=%v\n", key, value))
-		if err != nil {
-			fmt.Println("Error writing to server.properties:", err)
-			return
+	slog.Info("Successfully written content to server.properties")
+}
+
+// escapePropertiesValue escapes a value the way java.util.Properties stores it, so the server reads back exactly the
+// value that was written
+func escapePropertiesValue(value string) string {
+	var escaped strings.Builder
+	for i, r := range value {
+		switch {
+		case r == ' ' && i == 0:
+			escaped.WriteString(`\ `)
+		case r == '\\' || r == ':' || r == '=' || r == '#' || r == '!':
+			escaped.WriteString(`\` + string(r))
+		case r == '\t':
+			escaped.WriteString(`\t`)
+		case r == '\f':
+			escaped.WriteString(`\f`)
+		case r < 0x20 || r > 0x7e:
+			for _, unit := range utf16.Encode([]rune{r}) {
+				escaped.WriteString(fmt.Sprintf(`\u%04X`, unit))
+			}
+		default:
+			escaped.WriteRune(r)
 		}
 	}
-	slog.Info("Successfully written content to server.properties")
+	return escaped.String()
 }
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	JvmFlagsPresetNone  = "NONE"
+	JvmFlagsPresetAikar = "AIKAR"
+)
+
+var JvmFlagsPresets = []string{JvmFlagsPresetNone, JvmFlagsPresetAikar}
+
+var (
+	CgroupRoot  = "/sys/fs/cgroup"
+	MemInfoPath = "/proc/meminfo"
+)
+
+// cgroupUnlimited is the smallest cgroup v1 limit that means no limit, v1 reports unlimited as a page aligned
+// max int64
+const cgroupUnlimited = 1 << 62
+
+// aikarFlags are the G1 tuning flags from https://docs.papermc.io/paper/aikars-flags, heaps of 12G and more use
+// larger young generation and region sizes
+func aikarFlags(heapMb uint64) []string {
+	newSize, maxNewSize, regionSize, reserve, occupancy := "30", "40", "8M", "20", "15"
+	if heapMb >= 12*1024 {
+		newSize, maxNewSize, regionSize, reserve, occupancy = "40", "50", "16M", "15", "20"
+	}
+	return []string{
+		"-XX:+UseG1GC",
+		"-XX:+ParallelRefProcEnabled",
+		"-XX:MaxGCPauseMillis=200",
+		"-XX:+UnlockExperimentalVMOptions",
+		"-XX:+DisableExplicitGC",
+		"-XX:+AlwaysPreTouch",
+		"-XX:G1NewSizePercent=" + newSize,
+		"-XX:G1MaxNewSizePercent=" + maxNewSize,
+		"-XX:G1HeapRegionSize=" + regionSize,
+		"-XX:G1ReservePercent=" + reserve,
+		"-XX:G1HeapWastePercentage=5",
+		"-XX:G1MixedGCCountTarget=4",
+		"-XX:InitiatingHeapOccupancyPercent=" + occupancy,
+		"-XX:G1MixedGCLiveThresholdPercent=90",
+		"-XX:G1RSetUpdatingPauseTimePercent=5",
+		"-XX:SurvivorRatio=32",
+		"-XX:+PerfDisableSharedMem",
+		"-XX:MaxTenuringThreshold=1",
+		"-Dusing.aikars.flags=https://mcflags.emc.gs",
+		"-Daikars.new.flags=true",
+	}
+}
+
+// readMemoryLimit returns the memory limit of the container in bytes and where it was read from. Without a cgroup
+// limit the memory of the host is used
+func readMemoryLimit() (uint64, string, error) {
+	for _, limitPath := range []string{
+		filepath.Join(CgroupRoot, "memory.max"),
+		filepath.Join(CgroupRoot, "memory", "memory.limit_in_bytes"),
+	} {
+		content, err := os.ReadFile(limitPath)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return 0, "", err
+		}
+		value := strings.TrimSpace(string(content))
+		if value == "max" {
+			break
+		}
+		limit, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return 0, "", errors.New("invalid memory limit in " + limitPath + ": " + value)
+		}
+		if limit >= cgroupUnlimited {
+			break
+		}
+		return limit, limitPath, nil
+	}
+
+	content, err := os.ReadFile(MemInfoPath)
+	if err != nil {
+		return 0, "", errors.New("no cgroup memory limit and failed to read " + MemInfoPath + ": " + err.Error())
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			total, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, "", errors.New("invalid MemTotal in " + MemInfoPath)
+			}
+			return total * 1024, MemInfoPath, nil
+		}
+	}
+	return 0, "", errors.New("no cgroup memory limit and no MemTotal in " + MemInfoPath)
+}
+
+// heapSizeMb leaves the reserve for the rest of the JVM and the OS and uses percent of what remains as heap
+func heapSizeMb(limit uint64, percent uint, reserveMb uint) (uint64, error) {
+	limitMb := limit / 1024 / 1024
+	if limitMb <= uint64(reserveMb) {
+		return 0, fmt.Errorf("memory limit of %dM leaves nothing after reserving %dM", limitMb, reserveMb)
+	}
+	heapMb := (limitMb - uint64(reserveMb)) * uint64(percent) / 100
+	if heapMb == 0 {
+		return 0, fmt.Errorf("memory limit of %dM is too small for a heap", limitMb)
+	}
+	return heapMb, nil
+}
+
+// jvmArgs builds the arguments written to the JVM args file, one per line
+func jvmArgs(filesInit filesInit, heapMb uint64) []string {
+	args := []string{fmt.Sprintf("-Xms%dM", heapMb), fmt.Sprintf("-Xmx%dM", heapMb)}
+	if filesInit.JvmFlagsPreset == JvmFlagsPresetAikar {
+		args = append(args, aikarFlags(heapMb)...)
+	}
+	return append(args, filesInit.JvmExtraArgs...)
+}
+
+// writeJvmArgs writes heap sizes matching the memory limit to the JVM args file, start scripts pass it to java with
+// @user_jvm_args.txt the way Forge and NeoForge do
+func writeJvmArgs(filesInit filesInit, serverFolderPath string) {
+	if filesInit.JvmArgsFile == "" {
+		return
+	}
+	limit, source, err := readMemoryLimit()
+	if err != nil {
+		panic("Failed to read memory limit: " + err.Error())
+	}
+	heapMb, err := heapSizeMb(limit, filesInit.HeapPercent, filesInit.HeapReserveMb)
+	if err != nil {
+		panic("Failed to compute heap size: " + err.Error())
+	}
+	slog.Info(fmt.Sprintf("Read memory limit of %dM from %s, using a heap of %dM", limit/1024/1024, source, heapMb))
+
+	argsPath, err := resolveArchiveMemberPath(serverFolderPath, filesInit.JvmArgsFile)
+	if err != nil {
+		panic("Refusing to write JVM args file: " + err.Error())
+	}
+	content := "# Generated by omsms-server-init from the container memory limit\n" + strings.Join(jvmArgs(filesInit, heapMb), "\n") + "\n"
+	if err := ServerFiles.MkdirAll(filepath.Dir(argsPath), os.ModePerm); err != nil {
+		panic("Failed to create directory for JVM args file: " + err.Error())
+	}
+	if err := ServerFiles.WriteFile(argsPath, []byte(content), 0644); err != nil {
+		panic("Failed to write JVM args file " + argsPath + ": " + err.Error())
+	}
+	slog.Info("Successfully written JVM args to " + argsPath)
+}
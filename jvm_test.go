@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func setTestMemory(t *testing.T, files map[string]string) {
+	root := t.TempDir()
+	for name, content := range files {
+		if err := os.MkdirAll(path.Dir(path.Join(root, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cgroupRoot, memInfoPath := CgroupRoot, MemInfoPath
+	t.Cleanup(func() { CgroupRoot, MemInfoPath = cgroupRoot, memInfoPath })
+	CgroupRoot = path.Join(root, "cgroup")
+	MemInfoPath = path.Join(root, "meminfo")
+}
+
+func TestReadMemoryLimit(t *testing.T) {
+	cases := []struct {
+		files map[string]string
+		limit uint64
+	}{
+		{map[string]string{"cgroup/memory.max": "4294967296\n"}, 4 << 30},
+		{map[string]string{"cgroup/memory/memory.limit_in_bytes": "2147483648\n"}, 2 << 30},
+		{map[string]string{"cgroup/memory.max": "max\n", "meminfo": "MemTotal:       16384000 kB\nMemFree: 1 kB\n"}, 16384000 * 1024},
+		{map[string]string{"cgroup/memory/memory.limit_in_bytes": "9223372036854771712\n", "meminfo": "MemTotal: 1024 kB\n"}, 1024 * 1024},
+	}
+	for _, c := range cases {
+		setTestMemory(t, c.files)
+		limit, source, err := readMemoryLimit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if limit != c.limit {
+			t.Fatalf("Expected a limit of %d from %v but got %d from %s", c.limit, c.files, limit, source)
+		}
+	}
+
+	setTestMemory(t, map[string]string{})
+	if _, _, err := readMemoryLimit(); err == nil {
+		t.Fatal("Expected an error without any memory information")
+	}
+}
+
+func TestHeapSize(t *testing.T) {
+	heapMb, err := heapSizeMb(4<<30, 80, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if heapMb != 2457 {
+		t.Fatalf("Expected a heap of 2457M but got %dM", heapMb)
+	}
+	if _, err := heapSizeMb(512<<20, 80, 1024); err == nil {
+		t.Fatal("Expected an error when the reserve exceeds the limit")
+	}
+}
+
+func TestWriteJvmArgs(t *testing.T) {
+	setTestMemory(t, map[string]string{"cgroup/memory.max": "17179869184"})
+	serverDir := t.TempDir()
+	filesInit := filesInitDefault
+	filesInit.JvmArgsFile = "user_jvm_args.txt"
+	filesInit.JvmFlagsPreset = JvmFlagsPresetAikar
+	filesInit.JvmExtraArgs = []string{"-Dducky=true"}
+
+	writeJvmArgs(filesInit, serverDir)
+
+	content, err := os.ReadFile(path.Join(serverDir, "user_jvm_args.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, arg := range []string{"\n-Xms12288M\n", "\n-Xmx12288M\n", "\n-XX:G1HeapRegionSize=16M\n", "\n-Dducky=true\n"} {
+		if !strings.Contains(string(content), arg) {
+			t.Fatalf("Expected %q in the JVM args but got:\n%s", arg, content)
+		}
+	}
+}
+
+func TestValidateHeapPercentOnlyWithJvmArgsFile(t *testing.T) {
+	filesInit := filesInitDefault
+	filesInit.HeapPercent = 0
+	expectValid(t, validateFilesInit, filesInit)
+
+	filesInit.JvmArgsFile = "user_jvm_args.txt"
+	expectInvalid(t, validateFilesInit, filesInit, "HeapPercent")
+}
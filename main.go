@@ -1,106 +1,260 @@
 package main
 
 import (
-	"encoding/json"
+	"flag"
 	"fmt"
-	"github.com/go-git/go-git/v5"
 	"log/slog"
 	"os"
-	"path"
+	"reflect"
+	"strconv"
+	"strings"
 )
 
 const (
-	DeploymentTypeZip = "ZIP"
-	DeploymentTypeGit = "GIT"
+	DeploymentTypeZip  = "ZIP"
+	DeploymentTypeGit  = "GIT"
+	DeploymentTypeFile = "FILE"
 )
 
 var (
-	DeploymentTypes = []string{DeploymentTypeZip, DeploymentTypeGit}
+	DeploymentTypes = []string{DeploymentTypeZip, DeploymentTypeGit, DeploymentTypeFile}
 	ServerMountPath = "/minecraft-server"
 )
 
 type envs struct {
 	filesInit       filesInit
-	deploymentType  string
-	deploymentValue string
+	sources         []deploymentSource
 	startScriptName string
+	cache           cacheConfig
+	proxy           string
 }
 
 func main() {
-	envs := getEnvs()
-
-	if envs.deploymentType == DeploymentTypeZip {
-		slog.Info("Deploying server from zip file...")
-		downloadAndExtractZip(envs.deploymentValue, ServerMountPath)
-		err := os.Chmod(path.Join(ServerMountPath, envs.startScriptName), 0755)
-		if err != nil {
-			panic(err)
-		}
-		slog.Info(fmt.Sprintf("Successfully changed file permission for start script: %s", path.Join(ServerMountPath, envs.startScriptName)))
-		initServerFiles(envs.filesInit, envs.startScriptName, ServerMountPath)
-	}
-
-	if envs.deploymentType == DeploymentTypeGit {
-		slog.Info("Deploying server from git repository...")
-		_, err := git.PlainClone(ServerMountPath, false, &git.CloneOptions{
-			URL:      envs.deploymentValue,
-			Progress: os.Stdout,
-		})
-		if err != nil {
-			panic("Failed to clone repository:" + err.Error())
-		}
+	flag.Parse()
+	slog.SetDefault(slog.New(newRedactingHandler(slog.NewTextHandler(os.Stderr, nil))))
+
+	if code := runCommand(flag.Arg(0), os.Stdout, os.Stderr); code != 0 {
+		os.Exit(code)
 	}
 }
 
+// getEnvs reads the configuration and panics with every problem found in it
 func getEnvs() envs {
-	// Get and validate fileInitString
-	fileInitString := os.Getenv("OMSMS_SERVER_FILES_INIT")
-	if fileInitString == "" {
-		panic("OMSMS_SERVER_FILES_INIT environment variable not set")
-	}
-	filesInit := filesInitDefault
-	err := json.Unmarshal([]byte(fileInitString), &filesInit)
+	envs, err := readEnvs()
 	if err != nil {
-		panic("Failed to decode config" + err.Error())
+		panic(err.Error())
 	}
+	return envs
+}
 
-	// Get and validate deploymentType
-	deploymentType := os.Getenv("OMSMS_SERVER_DEPLOYMENT_TYPE")
-	if deploymentType == "" {
-		panic("OMSMS_SERVER_DEPLOYMENT_TYPE environment variable not set")
+// getEnv reads an environment variable, or the secret file named by the same variable with a _FILE suffix. Values
+// of secretEnvs read from files are never logged
+func getEnv(name string, problems *validationErrors) string {
+	value := os.Getenv(name)
+	fileName := os.Getenv(name + "_FILE")
+	if fileName == "" {
+		return value
 	}
-	if !checkStringMatches(deploymentType, DeploymentTypes) {
-		panic("Invalid Deployment Type: " + deploymentType)
+	if value != "" {
+		problems.add(name+"_FILE", "set either "+name+" or "+name+"_FILE")
+		return value
 	}
+	value, err := readSecretFile(fileName)
+	if err != nil {
+		problems.add(name+"_FILE", err.Error())
+		return ""
+	}
+	if secretEnvs[name] {
+		registerSecret(value)
+	}
+	return value
+}
+
+// readEnvs reads the config file and the environment variables, problems are collected instead of stopping at the
+// first one
+func readEnvs() (envs, error) {
+	var problems validationErrors
 
-	// Get and validate deploymentValue
-	deploymentValue := os.Getenv("OMSMS_SERVER_DEPLOYMENT_VALUE")
-	if deploymentValue == "" {
-		panic("OMSMS_SERVER_DEPLOYMENT_VALUE environment variable not set")
+	// Values from the optional config file are overridden by environment variables
+	var config configFile
+	configFilePath := getConfigFilePath()
+	if configFilePath != "" {
+		config = loadConfigFile(configFilePath, &problems)
+		slog.Info("Successfully read config file " + configFilePath)
 	}
-	if !isURL(deploymentValue) {
-		panic("Invalid deployment url: " + deploymentValue + " for type: " + deploymentType)
+
+	// Get and validate fileInitString, its fields are applied on top of the config file
+	fileInitString := getEnv("OMSMS_SERVER_FILES_INIT", &problems)
+	if fileInitString == "" && config.FilesInit == nil {
+		problems.add("OMSMS_SERVER_FILES_INIT", "environment variable not set")
 	}
+	filesInit := filesInitDefault
+	if config.FilesInit != nil {
+		decodeStrict("FilesInit", config.FilesInit, &filesInit, &problems)
+	}
+	var filesInitEnv any
+	if fileInitString != "" {
+		filesInitEnv = decodeStrict("OMSMS_SERVER_FILES_INIT", []byte(fileInitString), &filesInit, &problems)
+	}
+	// Problems are reported under the layer the field was last set by
+	filesInitField := func(field string) string {
+		if hasField(filesInitEnv, field) {
+			return "OMSMS_SERVER_FILES_INIT." + field
+		}
+		return "FilesInit." + field
+	}
+	filesInit = validateFilesInit(filesInitField, filesInit, &problems)
 
-	startScriptName := os.Getenv("OMSMS_SERVER_START_SCRIPT_NAME")
+	// Get and validate deploymentValue, which is either a single url or a JSON list of sources
+	deploymentValue := getEnv("OMSMS_SERVER_DEPLOYMENT_VALUE", &problems)
+	if deploymentValue == "" && len(config.Sources) == 0 {
+		problems.add("OMSMS_SERVER_DEPLOYMENT_VALUE", "environment variable not set")
+	}
+	deploymentType := getEnv("OMSMS_SERVER_DEPLOYMENT_TYPE", &problems)
+	isSourceList := deploymentValue == "" || isJsonArray(deploymentValue)
+
+	startScriptName := getEnv("OMSMS_SERVER_START_SCRIPT_NAME", &problems)
+	startScriptField := "OMSMS_SERVER_START_SCRIPT_NAME"
 	if startScriptName == "" {
-		panic("OMSMS_SERVER_DEPLOYMENT_VALUE environment variable not set")
+		startScriptName = config.StartScriptName
+		startScriptField = "StartScriptName"
 	}
-	if startScriptName[0] == '/' {
-		startScriptName = startScriptName[1:]
+	// Without a start script name the start script is detected or generated after the deployment
+	if startScriptName != "" {
+		startScriptName = strings.TrimPrefix(startScriptName, "/")
+		cleanName := cleanArchiveMemberName(startScriptName)
+		if cleanName == "." || isEscapingPath(cleanName) || strings.HasSuffix(startScriptName, "/") {
+			problems.add(startScriptField, "invalid start script name "+strconv.Quote(startScriptName))
+		}
 	}
 
+	// The mirror, hash, archive and filter variables describe the single source, a source list sets them per source
+	mirrors := getEnv("OMSMS_SERVER_DEPLOYMENT_MIRRORS", &problems)
+	hash := getEnv("OMSMS_SERVER_DEPLOYMENT_HASH", &problems)
+	authString := getEnv("OMSMS_SERVER_DEPLOYMENT_AUTH", &problems)
+	stripComponents := getEnv("OMSMS_SERVER_ARCHIVE_STRIP_COMPONENTS", &problems)
+	subpath := getEnv("OMSMS_SERVER_ARCHIVE_SUBPATH", &problems)
+	includeFiles := getEnv("OMSMS_SERVER_INCLUDE_FILES", &problems)
+	excludeFiles := getEnv("OMSMS_SERVER_EXCLUDE_FILES", &problems)
+
+	// The download cache is optional, its size only matters when a cache directory is set
+	cache := cacheConfig{Dir: getCacheDir(&problems), MaxSizeMb: DefaultCacheMaxSizeMb}
+	if maxSize := getEnv("OMSMS_CACHE_MAX_SIZE_MB", &problems); maxSize != "" {
+		size, err := strconv.ParseInt(maxSize, 10, 64)
+		if err != nil || size <= 0 {
+			problems.add("OMSMS_CACHE_MAX_SIZE_MB", "invalid value "+strconv.Quote(maxSize)+", expected a positive number of megabytes")
+		}
+		cache.MaxSizeMb = size
+	}
+
+	// The proxy from the config file or OMSMS_PROXY replaces HTTP_PROXY and HTTPS_PROXY
+	proxy := getEnv("OMSMS_PROXY", &problems)
+	proxyField := "OMSMS_PROXY"
+	if proxy == "" {
+		proxy = config.Proxy
+		proxyField = "Proxy"
+	}
+	if proxy != "" {
+		if _, err := parseProxy(proxy); err != nil {
+			problems.add(proxyField, err.Error())
+		}
+	}
+
+	var sources []deploymentSource
+	sourceField := func(i int) fieldNamer {
+		if deploymentValue != "" {
+			return fieldPrefix(fmt.Sprintf("OMSMS_SERVER_DEPLOYMENT_VALUE[%d]", i))
+		}
+		return fieldPrefix(fmt.Sprintf("Sources[%d]", i))
+	}
+	if isSourceList {
+		if mirrors != "" || hash != "" || authString != "" || stripComponents != "" || subpath != "" || includeFiles != "" || excludeFiles != "" {
+			problems.add("OMSMS_SERVER_DEPLOYMENT_VALUE", "mirror, hash, auth, archive and filter environment variables cannot be combined with a source list, set them per source instead")
+		}
+		if deploymentType != "" {
+			problems.add("OMSMS_SERVER_DEPLOYMENT_TYPE", "cannot be combined with a source list, set Type per source instead")
+		}
+		sources = config.Sources
+		if deploymentValue != "" {
+			sources = nil
+			decodeStrict("OMSMS_SERVER_DEPLOYMENT_VALUE", []byte(deploymentValue), &sources, &problems)
+			if len(sources) == 0 {
+				problems.add("OMSMS_SERVER_DEPLOYMENT_VALUE", "contains no deployment sources")
+			}
+		}
+	} else {
+		source := deploymentSource{
+			Type:    deploymentType,
+			Url:     deploymentValue,
+			Mirrors: splitList(mirrors),
+			Hash:    hash,
+			Subpath: subpath,
+			Include: splitList(includeFiles),
+			Exclude: splitList(excludeFiles),
+		}
+		if authString != "" {
+			decodeStrict("OMSMS_SERVER_DEPLOYMENT_AUTH", []byte(authString), &source.Auth, &problems)
+		}
+		if stripComponents != "" && stripComponents != "auto" {
+			count, err := strconv.Atoi(stripComponents)
+			if err != nil || count < 0 {
+				problems.add("OMSMS_SERVER_ARCHIVE_STRIP_COMPONENTS", "invalid value "+strconv.Quote(stripComponents)+", expected auto or a non-negative number")
+			} else {
+				source.StripComponents = &count
+			}
+		}
+		if len(config.Sources) > 0 {
+			slog.Warn(fmt.Sprintf("OMSMS_SERVER_DEPLOYMENT_VALUE replaces the %d sources from the config file", len(config.Sources)))
+		}
+		sources = []deploymentSource{source}
+		// The single source is described by separate environment variables
+		envNames := map[string]string{
+			"Type":            "OMSMS_SERVER_DEPLOYMENT_TYPE",
+			"Mirrors":         "OMSMS_SERVER_DEPLOYMENT_MIRRORS",
+			"Hash":            "OMSMS_SERVER_DEPLOYMENT_HASH",
+			"Auth":            "OMSMS_SERVER_DEPLOYMENT_AUTH",
+			"StripComponents": "OMSMS_SERVER_ARCHIVE_STRIP_COMPONENTS",
+			"Subpath":         "OMSMS_SERVER_ARCHIVE_SUBPATH",
+			"Include":         "OMSMS_SERVER_INCLUDE_FILES",
+			"Exclude":         "OMSMS_SERVER_EXCLUDE_FILES",
+		}
+		sourceField = func(int) fieldNamer {
+			return func(field string) string {
+				if envName, ok := envNames[field]; ok {
+					return envName
+				}
+				return "OMSMS_SERVER_DEPLOYMENT_VALUE"
+			}
+		}
+	}
+	for i, source := range sources {
+		sources[i] = validateDeploymentSource(sourceField(i), source, &problems)
+	}
+
+	if err := problems.err(); err != nil {
+		return envs{}, err
+	}
+	registerSecrets(reflect.ValueOf(filesInit))
+	registerSecrets(reflect.ValueOf(sources))
+
 	slog.Info(fmt.Sprintf(`Successfully read environmental variables:
 OMSMS_SERVER_DEPLOYMENT_TYPE: %s
 OMSMS_SERVER_DEPLOYMENT_Value: %s
+OMSMS_SERVER_DEPLOYMENT_MIRRORS: %s
+OMSMS_SERVER_DEPLOYMENT_HASH: %s
 OMSMS_SERVER_START_SCRIPT_NAME: %s
 OMSMS_SERVER_FILES_INIT: %s
-`, deploymentType, deploymentValue, startScriptName, fileInitString))
+OMSMS_SERVER_ARCHIVE_STRIP_COMPONENTS: %s
+OMSMS_SERVER_ARCHIVE_SUBPATH: %s
+OMSMS_SERVER_INCLUDE_FILES: %s
+OMSMS_SERVER_EXCLUDE_FILES: %s
+`, deploymentType, deploymentValue, mirrors, hash, startScriptName, fileInitString, stripComponents, subpath, includeFiles, excludeFiles))
 
 	return envs{
 		filesInit:       filesInit,
-		deploymentType:  deploymentType,
-		deploymentValue: deploymentValue,
+		sources:         sources,
 		startScriptName: startScriptName,
-	}
+		cache:           cache,
+		proxy:           proxy,
+	}, nil
 }
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	PatchFormatYaml  = "YAML"
+	PatchFormatToml  = "TOML"
+	PatchFormatJson  = "JSON"
+	PatchFormatJson5 = "JSON5"
+)
+
+var PatchFormats = []string{PatchFormatYaml, PatchFormatToml, PatchFormatJson, PatchFormatJson5}
+
+// configPatch changes values in a structured config file that was deployed to the server folder
+type configPatch struct {
+	// Path is relative to the server folder
+	Path string
+	// Format is one of YAML, TOML, JSON and JSON5, defaults to the format matching the file extension
+	Format string
+	// Set maps dotted key paths to values, a literal dot in a key is escaped as \.
+	Set map[string]any
+	// Merge is a document that is deep merged into the file, objects are merged and everything else is replaced
+	Merge map[string]any
+}
+
+// patchSet assigns value to the key path, missing objects along the path are created
+type patchSet struct {
+	path  []string
+	value any
+}
+
+// validateConfigPatch checks a patch and fills in its defaults, name maps its fields to the reported paths
+func validateConfigPatch(name fieldNamer, patch configPatch, problems *validationErrors) configPatch {
+	path := cleanArchiveMemberName(patch.Path)
+	if patch.Path == "" || path == "." || isEscapingPath(path) {
+		problems.add(name("Path"), "invalid path "+strconv.Quote(patch.Path))
+	}
+	patch.Path = path
+
+	if patch.Format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yml", ".yaml":
+			patch.Format = PatchFormatYaml
+		case ".toml":
+			patch.Format = PatchFormatToml
+		case ".json":
+			patch.Format = PatchFormatJson
+		case ".json5":
+			patch.Format = PatchFormatJson5
+		default:
+			problems.add(name("Format"), "cannot infer the format of "+path+", set Format to one of "+strings.Join(PatchFormats, ", "))
+		}
+	}
+	patch.Format = strings.ToUpper(patch.Format)
+	if patch.Format != "" && !checkStringMatches(patch.Format, PatchFormats) {
+		problems.add(name("Format"), "invalid format "+strconv.Quote(patch.Format)+", expected one of "+strings.Join(PatchFormats, ", "))
+	}
+
+	if len(patch.Set) == 0 && len(patch.Merge) == 0 {
+		problems.add(name("Set"), "needs Set or Merge")
+	}
+	for _, keyPath := range sortedKeys(patch.Set) {
+		if _, err := splitKeyPath(keyPath); err != nil {
+			problems.add(name("Set"), "invalid key path "+strconv.Quote(keyPath)+": "+err.Error())
+		}
+	}
+	return patch
+}
+
+// splitKeyPath splits a dotted key path, backslash escapes a dot or another backslash
+func splitKeyPath(keyPath string) ([]string, error) {
+	var keys []string
+	var key strings.Builder
+	for i := 0; i < len(keyPath); i++ {
+		switch c := keyPath[i]; c {
+		case '\\':
+			if i+1 == len(keyPath) {
+				return nil, errors.New("key path ends with an escape")
+			}
+			i++
+			key.WriteByte(keyPath[i])
+		case '.':
+			keys = append(keys, key.String())
+			key.Reset()
+		default:
+			key.WriteByte(c)
+		}
+	}
+	keys = append(keys, key.String())
+	if slices.Contains(keys, "") {
+		return nil, errors.New("key path contains an empty key")
+	}
+	return keys, nil
+}
+
+// sets flattens the merge document and the set map into assignments, merged values come first so Set wins
+func (patch configPatch) sets() []patchSet {
+	var sets []patchSet
+	var flatten func(prefix []string, document map[string]any)
+	flatten = func(prefix []string, document map[string]any) {
+		for _, key := range sortedKeys(document) {
+			path := append(slices.Clone(prefix), key)
+			if nested, ok := document[key].(map[string]any); ok && len(nested) > 0 {
+				flatten(path, nested)
+				continue
+			}
+			sets = append(sets, patchSet{path: path, value: document[key]})
+		}
+	}
+	flatten(nil, patch.Merge)
+
+	for _, keyPath := range sortedKeys(patch.Set) {
+		path, _ := splitKeyPath(keyPath)
+		sets = append(sets, patchSet{path: path, value: patch.Set[keyPath]})
+	}
+	return sets
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func applyConfigPatches(patches []configPatch, serverFolderPath string) {
+	if len(patches) == 0 {
+		return
+	}
+	slog.Info(fmt.Sprintf("Applying %d config patches...", len(patches)))
+	for _, patch := range patches {
+		applyConfigPatch(patch, serverFolderPath)
+	}
+	slog.Info("Successfully applied config patches")
+}
+
+func applyConfigPatch(patch configPatch, serverFolderPath string) {
+	filePath, err := resolveArchiveMemberPath(serverFolderPath, patch.Path)
+	if err != nil {
+		panic("Refusing to patch config file: " + err.Error())
+	}
+	info, err := ServerFiles.Lstat(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		panic("Config patch target " + patch.Path + " does not exist in the server folder, check the path or add the file with ExtraFiles")
+	}
+	if err != nil {
+		panic("Failed to read config patch target " + patch.Path + ": " + err.Error())
+	}
+	data, err := ServerFiles.ReadFile(filePath)
+	if err != nil {
+		panic("Failed to read config patch target " + patch.Path + ": " + err.Error())
+	}
+
+	sets := patch.sets()
+	data, err = patchDocument(patch.Format, data, sets)
+	if err != nil {
+		panic("Failed to patch " + patch.Format + " config " + patch.Path + ": " + err.Error())
+	}
+
+	if err := ServerFiles.WriteFile(filePath, data, info.Mode().Perm()); err != nil {
+		panic("Failed to write patched config " + patch.Path + ": " + err.Error())
+	}
+	slog.Info(fmt.Sprintf("Successfully patched %d keys in %s", len(sets), patch.Path))
+}
+
+func patchDocument(format string, data []byte, sets []patchSet) ([]byte, error) {
+	switch format {
+	case PatchFormatYaml:
+		return patchYaml(data, sets)
+	case PatchFormatToml:
+		return patchToml(data, sets)
+	case PatchFormatJson:
+		return patchJson(data, sets, false)
+	case PatchFormatJson5:
+		return patchJson(data, sets, true)
+	}
+	return nil, errors.New("unsupported format " + format)
+}
+
+// joinKeyPath renders a key path for error messages
+func joinKeyPath(path []string) string {
+	escaped := make([]string, len(path))
+	for i, key := range path {
+		escaped[i] = strings.ReplaceAll(strings.ReplaceAll(key, `\`, `\\`), ".", `\.`)
+	}
+	return strings.Join(escaped, ".")
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonNode is a value in a JSON or JSON5 document, start and end are byte offsets into the source. Only the
+// positions are tracked, so values can be replaced in place without touching comments or formatting
+type jsonNode struct {
+	start    int
+	end      int
+	members  []jsonMember
+	elements []*jsonNode
+	isObject bool
+	isArray  bool
+}
+
+type jsonMember struct {
+	key      string
+	keyStart int
+	value    *jsonNode
+}
+
+type jsonScanner struct {
+	data  []byte
+	pos   int
+	json5 bool
+}
+
+// patchJson splices every set into the document text, json5 enables comments, single quoted strings, unquoted
+// keys and trailing commas
+func patchJson(data []byte, sets []patchSet, json5 bool) ([]byte, error) {
+	for _, set := range sets {
+		root, err := parseJsonDocument(data, json5)
+		if err != nil {
+			return nil, err
+		}
+		data, err = setJsonValue(data, root, set.path, set.value)
+		if err != nil {
+			return nil, errors.New(joinKeyPath(set.path) + ": " + err.Error())
+		}
+	}
+	if _, err := parseJsonDocument(data, json5); err != nil {
+		return nil, errors.New("patched document is invalid: " + err.Error())
+	}
+	return data, nil
+}
+
+func parseJsonDocument(data []byte, json5 bool) (*jsonNode, error) {
+	scanner := &jsonScanner{data: data, json5: json5}
+	if err := scanner.skipSpace(); err != nil {
+		return nil, err
+	}
+	root, err := scanner.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	if err := scanner.skipSpace(); err != nil {
+		return nil, err
+	}
+	if scanner.pos != len(data) {
+		return nil, scanner.errorf("unexpected content after the document")
+	}
+	return root, nil
+}
+
+func setJsonValue(data []byte, node *jsonNode, path []string, value any) ([]byte, error) {
+	switch {
+	case node.isObject:
+		for _, member := range node.members {
+			if member.key != path[0] {
+				continue
+			}
+			if len(path) > 1 {
+				return setJsonValue(data, member.value, path[1:], value)
+			}
+			encoded, err := encodeJson(value)
+			if err != nil {
+				return nil, err
+			}
+			return splice(data, member.value.start, member.value.end, encoded), nil
+		}
+
+		// Missing keys are added after the last member, nesting whatever is left of the path
+		for i := len(path) - 1; i > 0; i-- {
+			value = map[string]any{path[i]: value}
+		}
+		encoded, err := encodeJson(value)
+		if err != nil {
+			return nil, err
+		}
+		key, _ := json.Marshal(path[0])
+		if len(node.members) == 0 {
+			return splice(data, node.start+1, node.start+1, string(key)+": "+encoded), nil
+		}
+		last := node.members[len(node.members)-1]
+		separator := " "
+		if lineStart := bytes.LastIndexByte(data[:last.keyStart], '\n'); lineStart != -1 && bytes.IndexByte(data[node.start:last.keyStart], '\n') != -1 {
+			separator = "\n" + string(data[lineStart+1:last.keyStart])
+		}
+		return splice(data, last.value.end, last.value.end, ","+separator+string(key)+": "+encoded), nil
+
+	case node.isArray:
+		index, err := strconv.Atoi(path[0])
+		if err != nil || index < 0 || index >= len(node.elements) {
+			return nil, errors.New("array index " + path[0] + " is out of range")
+		}
+		if len(path) > 1 {
+			return setJsonValue(data, node.elements[index], path[1:], value)
+		}
+		encoded, err := encodeJson(value)
+		if err != nil {
+			return nil, err
+		}
+		return splice(data, node.elements[index].start, node.elements[index].end, encoded), nil
+	}
+	return nil, errors.New("key " + path[0] + " is not inside an object")
+}
+
+func encodeJson(value any) (string, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buffer.String(), "\n"), nil
+}
+
+func splice(data []byte, start int, end int, replacement string) []byte {
+	result := make([]byte, 0, len(data)-(end-start)+len(replacement))
+	result = append(result, data[:start]...)
+	result = append(result, replacement...)
+	return append(result, data[end:]...)
+}
+
+func (s *jsonScanner) errorf(format string, args ...any) error {
+	line := bytes.Count(s.data[:s.pos], []byte("\n")) + 1
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// skipSpace skips whitespace and, for JSON5, comments
+func (s *jsonScanner) skipSpace() error {
+	for s.pos < len(s.data) {
+		switch c := s.data[s.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			s.pos++
+		case s.json5 && bytes.HasPrefix(s.data[s.pos:], []byte("//")):
+			end := bytes.IndexByte(s.data[s.pos:], '\n')
+			if end == -1 {
+				s.pos = len(s.data)
+			} else {
+				s.pos += end + 1
+			}
+		case s.json5 && bytes.HasPrefix(s.data[s.pos:], []byte("/*")):
+			end := bytes.Index(s.data[s.pos+2:], []byte("*/"))
+			if end == -1 {
+				return s.errorf("unterminated comment")
+			}
+			s.pos += end + 4
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *jsonScanner) parseValue() (*jsonNode, error) {
+	if s.pos >= len(s.data) {
+		return nil, s.errorf("expected a value but the document ends")
+	}
+	node := &jsonNode{start: s.pos}
+	var err error
+	switch c := s.data[s.pos]; {
+	case c == '{':
+		node.isObject = true
+		err = s.parseObject(node)
+	case c == '[':
+		node.isArray = true
+		err = s.parseArray(node)
+	case c == '"' || s.json5 && c == '\'':
+		_, err = s.parseString()
+	default:
+		err = s.parseLiteral()
+	}
+	node.end = s.pos
+	return node, err
+}
+
+func (s *jsonScanner) parseObject(node *jsonNode) error {
+	s.pos++
+	for {
+		if err := s.skipSpace(); err != nil {
+			return err
+		}
+		if s.pos < len(s.data) && s.data[s.pos] == '}' {
+			s.pos++
+			return nil
+		}
+		if len(node.members) > 0 {
+			if s.pos >= len(s.data) || s.data[s.pos] != ',' {
+				return s.errorf("expected , or } in object")
+			}
+			s.pos++
+			if err := s.skipSpace(); err != nil {
+				return err
+			}
+			if s.json5 && s.pos < len(s.data) && s.data[s.pos] == '}' {
+				s.pos++
+				return nil
+			}
+		}
+
+		member := jsonMember{keyStart: s.pos}
+		var err error
+		if s.pos < len(s.data) && (s.data[s.pos] == '"' || s.json5 && s.data[s.pos] == '\'') {
+			member.key, err = s.parseString()
+		} else if s.json5 {
+			member.key, err = s.parseIdentifier()
+		} else {
+			err = s.errorf("expected a quoted key")
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := s.skipSpace(); err != nil {
+			return err
+		}
+		if s.pos >= len(s.data) || s.data[s.pos] != ':' {
+			return s.errorf("expected : after key %s", member.key)
+		}
+		s.pos++
+		if err := s.skipSpace(); err != nil {
+			return err
+		}
+		member.value, err = s.parseValue()
+		if err != nil {
+			return err
+		}
+		node.members = append(node.members, member)
+	}
+}
+
+func (s *jsonScanner) parseArray(node *jsonNode) error {
+	s.pos++
+	for {
+		if err := s.skipSpace(); err != nil {
+			return err
+		}
+		if s.pos < len(s.data) && s.data[s.pos] == ']' {
+			s.pos++
+			return nil
+		}
+		if len(node.elements) > 0 {
+			if s.pos >= len(s.data) || s.data[s.pos] != ',' {
+				return s.errorf("expected , or ] in array")
+			}
+			s.pos++
+			if err := s.skipSpace(); err != nil {
+				return err
+			}
+			if s.pos < len(s.data) && s.data[s.pos] == ']' {
+				if !s.json5 {
+					return s.errorf("trailing comma in array")
+				}
+				s.pos++
+				return nil
+			}
+		}
+		element, err := s.parseValue()
+		if err != nil {
+			return err
+		}
+		node.elements = append(node.elements, element)
+	}
+}
+
+func (s *jsonScanner) parseString() (string, error) {
+	quote := s.data[s.pos]
+	start := s.pos
+	s.pos++
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case '\\':
+			s.pos += 2
+		case quote:
+			s.pos++
+			raw := string(s.data[start:s.pos])
+			if quote == '\'' {
+				raw = `"` + strings.ReplaceAll(strings.ReplaceAll(raw[1:len(raw)-1], `\'`, `'`), `"`, `\"`) + `"`
+			}
+			var value string
+			if err := json.Unmarshal([]byte(raw), &value); err != nil {
+				// JSON5 allows escapes JSON does not know, the exact key value only matters for lookups
+				return raw[1 : len(raw)-1], nil
+			}
+			return value, nil
+		case '\n':
+			return "", s.errorf("unterminated string")
+		default:
+			s.pos++
+		}
+	}
+	return "", s.errorf("unterminated string")
+}
+
+func (s *jsonScanner) parseIdentifier() (string, error) {
+	start := s.pos
+	for s.pos < len(s.data) {
+		c := s.data[s.pos]
+		if c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || s.pos > start && c >= '0' && c <= '9' || c >= 0x80 {
+			s.pos++
+			continue
+		}
+		break
+	}
+	if s.pos == start {
+		return "", s.errorf("expected a key")
+	}
+	return string(s.data[start:s.pos]), nil
+}
+
+// parseLiteral consumes numbers, booleans and null, for JSON it also validates them
+func (s *jsonScanner) parseLiteral() error {
+	start := s.pos
+	for s.pos < len(s.data) && strings.IndexByte(",:]}[{ \t\r\n/\"'", s.data[s.pos]) == -1 {
+		s.pos++
+	}
+	literal := string(s.data[start:s.pos])
+	if literal == "" {
+		return s.errorf("expected a value")
+	}
+	if !s.json5 && !json.Valid([]byte(literal)) {
+		return s.errorf("invalid value %s", literal)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func expectPatched(t *testing.T, format string, document string, patch configPatch, expected string) {
+	patch.Path = "config." + strings.ToLower(format)
+	patch.Format = format
+	serverDir := t.TempDir()
+	if err := os.WriteFile(path.Join(serverDir, patch.Path), []byte(document), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	applyConfigPatch(expectValid(t, validateConfigPatch, patch), serverDir)
+
+	expectFileContent(t, path.Join(serverDir, patch.Path), expected)
+}
+
+func TestPatchYaml(t *testing.T) {
+	document := `# Paper global config
+chunk-loading:
+  # Players per second
+  player-max-chunk-load-rate: 100.0
+proxies:
+  velocity:
+    enabled: false # toggled by omsms
+    secret: ''
+`
+	expected := `# Paper global config
+chunk-loading:
+  # Players per second
+  player-max-chunk-load-rate: 50
+proxies:
+  velocity:
+    enabled: true # toggled by omsms
+    secret: ducky
+    online-mode: true
+`
+	expectPatched(t, PatchFormatYaml, document, configPatch{
+		Set: map[string]any{"chunk-loading.player-max-chunk-load-rate": 50.0, "proxies.velocity.enabled": true},
+		Merge: map[string]any{
+			"proxies": map[string]any{"velocity": map[string]any{"secret": "ducky", "online-mode": true}},
+		},
+	}, expected)
+}
+
+func TestPatchToml(t *testing.T) {
+	document := `# Forge server config
+title = "Ducky"
+
+[general]
+	# Maximum ducks
+	maxDucks = 10 # per chunk
+	names = [
+		"quack", # the loud one
+		"honk",
+	]
+
+[[spawn]]
+	biome = "river"
+`
+	expected := `# Forge server config
+title = "Goose"
+
+[general]
+	# Maximum ducks
+	maxDucks = 20 # per chunk
+	names = ["quack"]
+loud = true
+
+[[spawn]]
+	biome = "river"
+
+[client.render]
+fancy = false
+`
+	expectPatched(t, PatchFormatToml, document, configPatch{
+		Set: map[string]any{
+			"title":               "Goose",
+			"general.maxDucks":    20.0,
+			"general.names":       []any{"quack"},
+			"general.loud":        true,
+			"client.render.fancy": false,
+		},
+	}, expected)
+}
+
+func TestPatchTomlWithRootKey(t *testing.T) {
+	expectPatched(t, PatchFormatToml, "[general]\nmaxDucks = 10\n", configPatch{
+		Set: map[string]any{"enabled": true},
+	}, "enabled = true\n[general]\nmaxDucks = 10\n")
+}
+
+func TestPatchTomlKeepsFloats(t *testing.T) {
+	expectPatched(t, PatchFormatToml, "speed = 0.5\nscale = 1e3\nducks = 10\n", configPatch{
+		Set: map[string]any{"speed": 1.0, "scale": 2.0, "ducks": 20.0},
+	}, "speed = 1.0\nscale = 2.0\nducks = 20\n")
+}
+
+func TestPatchJson(t *testing.T) {
+	document := `{
+  "ducks": 10,
+  "names": ["quack", "honk"],
+  "nested": {}
+}`
+	expected := `{
+  "ducks": 20,
+  "names": ["quack", "goose"],
+  "nested": {"loud": true},
+  "new": {"key":"value"}
+}`
+	expectPatched(t, PatchFormatJson, document, configPatch{
+		Set: map[string]any{"ducks": 20.0, "names.1": "goose", "nested.loud": true, "new.key": "value"},
+	}, expected)
+}
+
+func TestPatchJson5(t *testing.T) {
+	document := `// Fabric mod config
+{
+  /* how many */
+  ducks: 10, // per pond
+  'name': 'quack',
+  list: [1, 2,],
+}
+`
+	expected := `// Fabric mod config
+{
+  /* how many */
+  ducks: 20, // per pond
+  'name': "goose",
+  list: [1, 2,],
+  "loud": true,
+}
+`
+	expectPatched(t, PatchFormatJson5, document, configPatch{
+		Set: map[string]any{"ducks": 20.0, "name": "goose", "loud": true},
+	}, expected)
+}
+
+func TestPatchWithMissingFile(t *testing.T) {
+	patch := expectValid(t, validateConfigPatch, configPatch{Path: "config/paper-global.yml", Set: map[string]any{"ducks": 1.0}})
+	expectPanic(t, func() { applyConfigPatch(patch, t.TempDir()) })
+}
+
+func TestPatchInsideScalar(t *testing.T) {
+	for format, document := range map[string]string{
+		PatchFormatYaml:  "ducks: 10\n",
+		PatchFormatToml:  "ducks = 10\n",
+		PatchFormatJson:  `{"ducks": 10}`,
+		PatchFormatJson5: `{ducks: 10}`,
+	} {
+		if _, err := patchDocument(format, []byte(document), []patchSet{{path: []string{"ducks", "loud"}, value: true}}); err == nil {
+			t.Fatalf("Expected patching inside a scalar to fail for %s", format)
+		}
+	}
+}
+
+func TestValidateConfigPatch(t *testing.T) {
+	if patch := expectValid(t, validateConfigPatch, configPatch{Path: "config/ducky-server.toml", Set: map[string]any{"a": 1.0}}); patch.Format != PatchFormatToml {
+		t.Fatalf("Expected format TOML but got %s", patch.Format)
+	}
+	invalidPatches := map[string]configPatch{
+		"Path":   {Path: "../server.yml", Set: map[string]any{"a": 1.0}},
+		"Format": {Path: "config/ducky.cfg", Set: map[string]any{"a": 1.0}},
+		"Set":    {Path: "config/ducky.yml", Set: map[string]any{"a..b": 1.0}},
+	}
+	for field, patch := range invalidPatches {
+		expectInvalid(t, validateConfigPatch, patch, field)
+	}
+	expectInvalid(t, validateConfigPatch, configPatch{Path: "config/ducky.yml"}, "Set")
+}
+
+func TestSplitKeyPath(t *testing.T) {
+	keys, err := splitKeyPath(`proxies.velocity\.toml.enabled`)
+	if err != nil || len(keys) != 3 || keys[1] != "velocity.toml" {
+		t.Fatalf("Expected three keys with velocity.toml in the middle but got %q (%v)", keys, err)
+	}
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// tomlTable is a [table] or [[array table]] header, lastValueEnd is -1 until the table has a key
+type tomlTable struct {
+	path         []string
+	isArray      bool
+	headerEnd    int
+	lastValueEnd int
+}
+
+// tomlKeyValue is a key = value line, path includes the keys of the enclosing table
+type tomlKeyValue struct {
+	path       []string
+	valueStart int
+	valueEnd   int
+}
+
+type tomlDocument struct {
+	root      tomlTable
+	tables    []*tomlTable
+	keyValues []tomlKeyValue
+}
+
+var (
+	tomlBareKey  = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	tomlDateTime = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+)
+
+// patchToml splices every set into the document text so comments and layout are kept, the result is checked with
+// a full TOML decoder before it is returned
+func patchToml(data []byte, sets []patchSet) ([]byte, error) {
+	for _, set := range sets {
+		document, err := scanTomlDocument(data)
+		if err != nil {
+			return nil, err
+		}
+		data, err = setTomlValue(data, document, set.path, set.value)
+		if err != nil {
+			return nil, errors.New(joinKeyPath(set.path) + ": " + err.Error())
+		}
+	}
+
+	var decoded map[string]any
+	if err := toml.Unmarshal(data, &decoded); err != nil {
+		return nil, errors.New("patched document is invalid: " + err.Error())
+	}
+	return data, nil
+}
+
+func setTomlValue(data []byte, document *tomlDocument, path []string, value any) ([]byte, error) {
+	encoded, err := encodeTomlValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, keyValue := range document.keyValues {
+		if slices.Equal(keyValue.path, path) {
+			// JSON has no separate float type, so a whole number replacing a float stays a float
+			if number, ok := value.(float64); ok && isTomlFloat(data[keyValue.valueStart:keyValue.valueEnd]) {
+				encoded = encodeTomlFloat(number)
+			}
+			return splice(data, keyValue.valueStart, keyValue.valueEnd, encoded), nil
+		}
+		if len(keyValue.path) < len(path) && slices.Equal(keyValue.path, path[:len(keyValue.path)]) {
+			return nil, errors.New("cannot patch inside the inline value of " + joinKeyPath(keyValue.path) + ", set the whole value instead")
+		}
+	}
+
+	// Add the key to the deepest table that contains it
+	table := &document.root
+	for _, candidate := range document.tables {
+		if len(candidate.path) < len(path) && slices.Equal(candidate.path, path[:len(candidate.path)]) {
+			if candidate.isArray {
+				return nil, errors.New("cannot patch inside array of tables " + joinKeyPath(candidate.path))
+			}
+			if len(candidate.path) > len(table.path) {
+				table = candidate
+			}
+		}
+	}
+
+	if table == &document.root && len(path) > 1 {
+		// Keys of a missing table get a new table at the end of the document
+		var addition strings.Builder
+		if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+			addition.WriteString("\n")
+		}
+		addition.WriteString("\n[" + encodeTomlKey(path[:len(path)-1]) + "]\n")
+		addition.WriteString(encodeTomlKey(path[len(path)-1:]) + " = " + encoded + "\n")
+		return append(data, addition.String()...), nil
+	}
+
+	line := encodeTomlKey(path[len(table.path):]) + " = " + encoded
+	if table == &document.root && table.lastValueEnd == -1 {
+		return splice(data, 0, 0, line+"\n"), nil
+	}
+	insertAt := table.lastValueEnd
+	if insertAt == -1 {
+		insertAt = table.headerEnd
+	}
+	// Insert on the line after the last key of the table
+	if lineEnd := bytes.IndexByte(data[insertAt:], '\n'); lineEnd != -1 {
+		return splice(data, insertAt+lineEnd+1, insertAt+lineEnd+1, line+"\n"), nil
+	}
+	return append(data, "\n"+line+"\n"...), nil
+}
+
+func encodeTomlKey(path []string) string {
+	keys := make([]string, len(path))
+	for i, key := range path {
+		if tomlBareKey.MatchString(key) {
+			keys[i] = key
+		} else {
+			keys[i] = strconv.Quote(key)
+		}
+	}
+	return strings.Join(keys, ".")
+}
+
+// encodeTomlValue renders a decoded JSON value as an inline TOML value
+func encodeTomlValue(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", errors.New("TOML has no null value")
+	case string:
+		return encodeJson(v)
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return strconv.FormatInt(int64(v), 10), nil
+		}
+		return encodeTomlFloat(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			encoded, err := encodeTomlValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = encoded
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case map[string]any:
+		items := make([]string, 0, len(v))
+		for _, key := range sortedKeys(v) {
+			encoded, err := encodeTomlValue(v[key])
+			if err != nil {
+				return "", err
+			}
+			items = append(items, encodeTomlKey([]string{key})+" = "+encoded)
+		}
+		return "{" + strings.Join(items, ", ") + "}", nil
+	}
+	return "", fmt.Errorf("unsupported value %v", value)
+}
+
+// encodeTomlFloat writes v as a TOML float, whole numbers get a .0 so they are not read as integers
+func encodeTomlFloat(v float64) string {
+	encoded := strconv.FormatFloat(v, 'g', -1, 64)
+	if !strings.ContainsAny(encoded, ".eE") {
+		encoded += ".0"
+	}
+	return encoded
+}
+
+// isTomlFloat reports whether the raw value of a key is a float, like 1.0, 5e3 or inf
+func isTomlFloat(raw []byte) bool {
+	var decoded map[string]any
+	if err := toml.Unmarshal(append([]byte("value = "), raw...), &decoded); err != nil {
+		return false
+	}
+	_, ok := decoded["value"].(float64)
+	return ok
+}
+
+type tomlScanner struct {
+	data []byte
+	pos  int
+}
+
+// scanTomlDocument records where tables and key values are, it is not a validating parser
+func scanTomlDocument(data []byte) (*tomlDocument, error) {
+	s := &tomlScanner{data: data}
+	document := &tomlDocument{root: tomlTable{lastValueEnd: -1}}
+	current := &document.root
+
+	for {
+		s.skipWhitespace(true)
+		if s.pos >= len(data) {
+			break
+		}
+		switch data[s.pos] {
+		case '#':
+			s.skipComment()
+		case '[':
+			isArray := bytes.HasPrefix(data[s.pos:], []byte("[["))
+			if isArray {
+				s.pos += 2
+			} else {
+				s.pos++
+			}
+			path, err := s.parseKey()
+			if err != nil {
+				return nil, err
+			}
+			closing := "]"
+			if isArray {
+				closing = "]]"
+			}
+			if !bytes.HasPrefix(data[s.pos:], []byte(closing)) {
+				return nil, s.errorf("expected %s after table name", closing)
+			}
+			s.pos += len(closing)
+			current = &tomlTable{path: path, isArray: isArray, headerEnd: s.pos, lastValueEnd: -1}
+			document.tables = append(document.tables, current)
+		default:
+			key, err := s.parseKey()
+			if err != nil {
+				return nil, err
+			}
+			if s.pos >= len(data) || data[s.pos] != '=' {
+				return nil, s.errorf("expected = after key")
+			}
+			s.pos++
+			s.skipWhitespace(false)
+			valueStart := s.pos
+			if err := s.skipValue(); err != nil {
+				return nil, err
+			}
+			// Keys inside arrays of tables are not addressable by path
+			if !current.isArray {
+				path := append(slices.Clone(current.path), key...)
+				document.keyValues = append(document.keyValues, tomlKeyValue{path: path, valueStart: valueStart, valueEnd: s.pos})
+			}
+			current.lastValueEnd = s.pos
+		}
+	}
+	return document, nil
+}
+
+func (s *tomlScanner) errorf(format string, args ...any) error {
+	line := bytes.Count(s.data[:s.pos], []byte("\n")) + 1
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func (s *tomlScanner) skipWhitespace(newlines bool) {
+	for s.pos < len(s.data) {
+		c := s.data[s.pos]
+		if c == ' ' || c == '\t' || newlines && (c == '\n' || c == '\r') {
+			s.pos++
+			continue
+		}
+		return
+	}
+}
+
+func (s *tomlScanner) skipComment() {
+	end := bytes.IndexByte(s.data[s.pos:], '\n')
+	if end == -1 {
+		s.pos = len(s.data)
+	} else {
+		s.pos += end
+	}
+}
+
+// skipSpace skips whitespace, newlines and comments inside arrays
+func (s *tomlScanner) skipSpace() {
+	for {
+		s.skipWhitespace(true)
+		if s.pos < len(s.data) && s.data[s.pos] == '#' {
+			s.skipComment()
+			continue
+		}
+		return
+	}
+}
+
+// parseKey reads a possibly dotted key and the whitespace after it
+func (s *tomlScanner) parseKey() ([]string, error) {
+	var path []string
+	for {
+		s.skipWhitespace(false)
+		if s.pos >= len(s.data) {
+			return nil, s.errorf("expected a key")
+		}
+		switch s.data[s.pos] {
+		case '"':
+			start := s.pos
+			if err := s.skipString(); err != nil {
+				return nil, err
+			}
+			key, err := strconv.Unquote(string(s.data[start:s.pos]))
+			if err != nil {
+				return nil, s.errorf("invalid quoted key %s", s.data[start:s.pos])
+			}
+			path = append(path, key)
+		case '\'':
+			start := s.pos
+			if err := s.skipString(); err != nil {
+				return nil, err
+			}
+			path = append(path, string(s.data[start+1:s.pos-1]))
+		default:
+			start := s.pos
+			for s.pos < len(s.data) && isTomlBareKeyByte(s.data[s.pos]) {
+				s.pos++
+			}
+			if s.pos == start {
+				return nil, s.errorf("expected a key")
+			}
+			path = append(path, string(s.data[start:s.pos]))
+		}
+		s.skipWhitespace(false)
+		if s.pos < len(s.data) && s.data[s.pos] == '.' {
+			s.pos++
+			continue
+		}
+		return path, nil
+	}
+}
+
+func isTomlBareKeyByte(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (s *tomlScanner) skipString() error {
+	for _, delimiter := range []string{`"""`, `'''`} {
+		if bytes.HasPrefix(s.data[s.pos:], []byte(delimiter)) {
+			s.pos += 3
+			for s.pos < len(s.data) {
+				if delimiter == `"""` && s.data[s.pos] == '\\' {
+					s.pos += 2
+					continue
+				}
+				if bytes.HasPrefix(s.data[s.pos:], []byte(delimiter)) {
+					s.pos += 3
+					// Up to two quotes may directly precede the closing delimiter
+					for i := 0; i < 2 && s.pos < len(s.data) && s.data[s.pos] == delimiter[0]; i++ {
+						s.pos++
+					}
+					return nil
+				}
+				s.pos++
+			}
+			return s.errorf("unterminated multi-line string")
+		}
+	}
+
+	quote := s.data[s.pos]
+	s.pos++
+	for s.pos < len(s.data) && s.data[s.pos] != '\n' {
+		if quote == '"' && s.data[s.pos] == '\\' {
+			s.pos += 2
+			continue
+		}
+		if s.data[s.pos] == quote {
+			s.pos++
+			return nil
+		}
+		s.pos++
+	}
+	return s.errorf("unterminated string")
+}
+
+func (s *tomlScanner) skipValue() error {
+	if s.pos >= len(s.data) {
+		return s.errorf("expected a value")
+	}
+	switch s.data[s.pos] {
+	case '"', '\'':
+		return s.skipString()
+	case '[':
+		s.pos++
+		for {
+			s.skipSpace()
+			if s.pos >= len(s.data) {
+				return s.errorf("unterminated array")
+			}
+			switch s.data[s.pos] {
+			case ']':
+				s.pos++
+				return nil
+			case ',':
+				s.pos++
+			default:
+				if err := s.skipValue(); err != nil {
+					return err
+				}
+			}
+		}
+	case '{':
+		s.pos++
+		for {
+			s.skipWhitespace(false)
+			if s.pos >= len(s.data) {
+				return s.errorf("unterminated inline table")
+			}
+			switch s.data[s.pos] {
+			case '}':
+				s.pos++
+				return nil
+			case ',':
+				s.pos++
+			default:
+				if _, err := s.parseKey(); err != nil {
+					return err
+				}
+				if s.pos >= len(s.data) || s.data[s.pos] != '=' {
+					return s.errorf("expected = in inline table")
+				}
+				s.pos++
+				s.skipWhitespace(false)
+				if err := s.skipValue(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	start := s.pos
+	for s.pos < len(s.data) && strings.IndexByte(" \t\r\n,]}#", s.data[s.pos]) == -1 {
+		s.pos++
+	}
+	// Date times may use a space instead of T between the date and the time
+	if tomlDateTime.Match(s.data[start:s.pos]) && s.pos+1 < len(s.data) && s.data[s.pos] == ' ' && s.data[s.pos+1] >= '0' && s.data[s.pos+1] <= '9' {
+		s.pos++
+		for s.pos < len(s.data) && strings.IndexByte(" \t\r\n,]}#", s.data[s.pos]) == -1 {
+			s.pos++
+		}
+	}
+	if s.pos == start {
+		return s.errorf("expected a value")
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// patchYaml edits the document through the yaml.v3 node tree, which keeps comments attached to their nodes
+func patchYaml(data []byte, sets []patchSet) ([]byte, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	if document.Kind == 0 {
+		document = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+
+	for _, set := range sets {
+		if err := setYamlValue(document.Content[0], set.path, set.value); err != nil {
+			return nil, errors.New(joinKeyPath(set.path) + ": " + err.Error())
+		}
+	}
+
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func setYamlValue(node *yaml.Node, path []string, value any) error {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	var child **yaml.Node
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == path[0] {
+				child = &node.Content[i+1]
+				break
+			}
+		}
+		if child == nil {
+			key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: path[0]}
+			node.Content = append(node.Content, key, &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"})
+			child = &node.Content[len(node.Content)-1]
+		}
+	case yaml.SequenceNode:
+		index, err := strconv.Atoi(path[0])
+		if err != nil || index < 0 || index >= len(node.Content) {
+			return errors.New("sequence index " + path[0] + " is out of range")
+		}
+		child = &node.Content[index]
+	default:
+		return errors.New("key " + path[0] + " is not inside a mapping")
+	}
+
+	if len(path) > 1 {
+		return setYamlValue(*child, path[1:], value)
+	}
+
+	var replacement yaml.Node
+	if err := replacement.Encode(value); err != nil {
+		return err
+	}
+	previous := *child
+	replacement.HeadComment = previous.HeadComment
+	replacement.LineComment = previous.LineComment
+	replacement.FootComment = previous.FootComment
+	*child = &replacement
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"strconv"
+)
+
+// DefaultExecutablePatterns select the files that are made executable when ExecutablePatterns is empty
+var DefaultExecutablePatterns = []string{"*.sh"}
+
+// parseMode parses an octal permission like 0644
+func parseMode(mode string) (os.FileMode, error) {
+	parsed, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || parsed > 0777 {
+		return 0, errors.New("invalid mode " + strconv.Quote(mode) + ", expected an octal permission like 0644")
+	}
+	return os.FileMode(parsed), nil
+}
+
+// permissionPolicy is the mode every directory and file in the server folder gets, files matching executable or
+// already executable, like a bundled JRE or native launcher, get the file mode with an exec bit for every read bit
+type permissionPolicy struct {
+	dirMode    os.FileMode
+	fileMode   os.FileMode
+	executable fileFilter
+	// kept are the files whose mode is configured elsewhere, like extra files with a Mode and the RCON password
+	kept map[string]bool
+}
+
+func newPermissionPolicy(filesInit filesInit, startScriptName string) (permissionPolicy, error) {
+	dirMode, err := parseMode(filesInit.DirMode)
+	if err != nil {
+		return permissionPolicy{}, err
+	}
+	fileMode, err := parseMode(filesInit.FileMode)
+	if err != nil {
+		return permissionPolicy{}, err
+	}
+	patterns := filesInit.ExecutablePatterns
+	if len(patterns) == 0 {
+		patterns = DefaultExecutablePatterns
+	}
+	if filesInit.SanitiseScripts {
+		patterns = append(patterns[:len(patterns):len(patterns)], filesInit.scriptPatterns()...)
+	}
+	if startScriptName != "" {
+		patterns = append(patterns[:len(patterns):len(patterns)], "/"+startScriptName)
+	}
+	executable, err := newFileFilter(patterns, nil)
+	if err != nil {
+		return permissionPolicy{}, err
+	}
+
+	kept := map[string]bool{}
+	for _, file := range filesInit.ExtraFiles {
+		if file.Mode != "" {
+			kept[cleanArchiveMemberName(file.Path)] = true
+		}
+	}
+	if filesInit.EnableRcon && !path.IsAbs(filesInit.RconPasswordPath) {
+		kept[cleanArchiveMemberName(filesInit.RconPasswordPath)] = true
+	}
+	return permissionPolicy{dirMode: dirMode, fileMode: fileMode, executable: executable, kept: kept}, nil
+}
+
+func (policy permissionPolicy) mode(name string, info os.FileInfo) (os.FileMode, bool) {
+	switch {
+	case info.Mode()&os.ModeSymlink != 0 || policy.kept[name]:
+		return 0, false
+	case info.IsDir():
+		return policy.dirMode, true
+	case policy.executable.allows(name) || info.Mode().Perm()&0111 != 0:
+		return policy.fileMode | policy.fileMode&0444>>2, true
+	}
+	return policy.fileMode, true
+}
+
+// changesOwner reports whether init hands its files to another user or group
+func (filesInit filesInit) changesOwner() bool {
+	uid, gid := filesInit.owner()
+	return uid >= 0 || gid >= 0
+}
+
+// owner returns the configured uid and gid, -1 for the ones that are kept
+func (filesInit filesInit) owner() (int, int) {
+	uid, gid := -1, -1
+	if filesInit.OwnerUid != nil {
+		uid = int(*filesInit.OwnerUid)
+	}
+	if filesInit.OwnerGid != nil {
+		gid = int(*filesInit.OwnerGid)
+	}
+	return uid, gid
+}
+
+// applyOwnership normalises the permissions of the server folder and hands it to the configured owner, so a game
+// container running as another user can read and write everything init deployed
+func applyOwnership(filesInit filesInit, startScriptName string, serverFolderPath string) {
+	chown := filesInit.changesOwner()
+	if !filesInit.NormalisePermissions && !chown {
+		return
+	}
+	var policy permissionPolicy
+	if filesInit.NormalisePermissions {
+		var err error
+		policy, err = newPermissionPolicy(filesInit, startScriptName)
+		if err != nil {
+			panic("Invalid permission policy: " + err.Error())
+		}
+	}
+	uid, gid := filesInit.owner()
+
+	var chmodded, chowned int
+	var apply func(name string, info os.FileInfo)
+	apply = func(name string, info os.FileInfo) {
+		filePath := path.Join(serverFolderPath, name)
+		if filesInit.NormalisePermissions {
+			if mode, ok := policy.mode(name, info); ok && info.Mode().Perm() != mode {
+				if err := ServerFiles.Chmod(filePath, mode); err != nil {
+					panic("Failed to change file permission of " + filePath + ": " + err.Error())
+				}
+				chmodded++
+			}
+		}
+		if chown {
+			if err := ServerFiles.Lchown(filePath, uid, gid); err != nil {
+				panic("Failed to change owner of " + filePath + ": " + err.Error())
+			}
+			chowned++
+		}
+		if !info.IsDir() {
+			return
+		}
+		entries, err := ServerFiles.ReadDir(filePath)
+		if err != nil {
+			panic("Failed to read " + filePath + ": " + err.Error())
+		}
+		for _, entry := range entries {
+			entryInfo, err := entry.Info()
+			if err != nil {
+				panic("Failed to read " + path.Join(filePath, entry.Name()) + ": " + err.Error())
+			}
+			apply(path.Join(name, entry.Name()), entryInfo)
+		}
+	}
+
+	info, err := ServerFiles.Lstat(serverFolderPath)
+	if err != nil {
+		panic("Failed to read server folder: " + err.Error())
+	}
+	apply(".", info)
+	slog.Info(fmt.Sprintf("Successfully changed the permissions of %d paths and the owner of %d paths", chmodded, chowned))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path"
+	"syscall"
+	"testing"
+)
+
+func expectMode(t *testing.T, filePath string, expected os.FileMode) {
+	t.Helper()
+	info, err := os.Lstat(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != expected {
+		t.Fatalf("Expected %s to have mode %04o but got %04o", filePath, expected, info.Mode().Perm())
+	}
+}
+
+func TestApplyOwnershipNormalisesPermissions(t *testing.T) {
+	serverDir := writeTestServerFiles(t, map[string]string{
+		"startserver":          "java -jar server.jar",
+		"server.jar":           "jar",
+		"scripts/backup.sh":    "tar czf backup.tgz world",
+		"config/ducky.toml":    "ducks = 10",
+		"ops.json":             "[]",
+		".omsms/rcon-password": "secret",
+	})
+	for name, mode := range map[string]os.FileMode{"server.jar": 0777, "config/ducky.toml": 0000, "config": 0700, ".omsms/rcon-password": 0600, "ops.json": 0640} {
+		if err := os.Chmod(path.Join(serverDir, name), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	filesInit := filesInitDefault
+	filesInit.NormalisePermissions = true
+	filesInit.FileMode = "0664"
+	filesInit.EnableRcon = true
+	filesInit.ExtraFiles = []extraFile{{Path: "ops.json", Mode: "0640"}}
+
+	applyOwnership(filesInit, "startserver", serverDir)
+
+	for name, mode := range map[string]os.FileMode{
+		".":                    0755,
+		"startserver":          0775,
+		"server.jar":           0775,
+		"scripts":              0755,
+		"scripts/backup.sh":    0775,
+		"config":               0755,
+		"config/ducky.toml":    0664,
+		"ops.json":             0640,
+		".omsms/rcon-password": 0600,
+	} {
+		expectMode(t, path.Join(serverDir, name), mode)
+	}
+}
+
+func TestApplyOwnershipKeepsPermissionsByDefault(t *testing.T) {
+	serverDir := writeTestServerFiles(t, map[string]string{"jre/bin/java": "elf", "config/ducky.toml": "ducks = 10"})
+	if err := os.Chmod(path.Join(serverDir, "jre/bin/java"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	applyOwnership(filesInitDefault, "", serverDir)
+
+	expectMode(t, path.Join(serverDir, "jre/bin/java"), 0700)
+	expectMode(t, path.Join(serverDir, "config/ducky.toml"), 0644)
+}
+
+func TestApplyOwnershipChangesOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Changing the owner needs root")
+	}
+	serverDir := writeTestServerFiles(t, map[string]string{"world/level.dat": "level"})
+	if err := os.Symlink("world/level.dat", path.Join(serverDir, "level.dat")); err != nil {
+		t.Fatal(err)
+	}
+	filesInit := filesInitDefault
+	owner := int64(1000)
+	filesInit.OwnerUid = &owner
+	filesInit.OwnerGid = &owner
+
+	applyOwnership(filesInit, "", serverDir)
+
+	for _, name := range []string{".", "world", "world/level.dat", "level.dat"} {
+		info, err := os.Lstat(path.Join(serverDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stat := info.Sys().(*syscall.Stat_t)
+		if stat.Uid != 1000 || stat.Gid != 1000 {
+			t.Fatalf("Expected %s to be owned by 1000:1000 but got %d:%d", name, stat.Uid, stat.Gid)
+		}
+	}
+}
+
+func TestValidatePermissionPolicy(t *testing.T) {
+	filesInit := filesInitDefault
+	filesInit.DirMode = "0999"
+	// The modes are only used when the permissions are normalised
+	expectValid(t, validateFilesInit, filesInit)
+	filesInit.NormalisePermissions = true
+	expectInvalid(t, validateFilesInit, filesInit, "DirMode")
+
+	filesInit = filesInitDefault
+	owner := int64(-2)
+	filesInit.OwnerUid = &owner
+	expectInvalid(t, validateFilesInit, filesInit, "OwnerUid")
+}
+
+func TestUnsetOwnerKeepsOwner(t *testing.T) {
+	// A payload that does not mention the owner decodes to a zero value, which must not hand the folder to root
+	var filesInit filesInit
+	if filesInit.changesOwner() {
+		t.Fatal("Expected an unset owner to keep the owner")
+	}
+	keep := int64(-1)
+	filesInit.OwnerUid = &keep
+	filesInit.OwnerGid = &keep
+	if filesInit.changesOwner() {
+		t.Fatal("Expected -1 to keep the owner")
+	}
+}
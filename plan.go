@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// printPlan describes every step init would take, without downloading anything or touching the server folder.
+// Archive and git contents are only known after downloading them, so their steps describe the merge instead
+func printPlan(w io.Writer, envs envs, serverFolderPath string) {
+	var steps []string
+	if envs.cache.Dir != "" {
+		steps = append(steps, fmt.Sprintf("use download cache %s, up to %dM", envs.cache.Dir, envs.cache.MaxSizeMb))
+	}
+	if envs.proxy != "" {
+		steps = append(steps, "download and clone through proxy "+redactString(envs.proxy))
+	}
+	for _, source := range envs.sources {
+		steps = append(steps, planSource(source, serverFolderPath))
+	}
+	for _, file := range envs.filesInit.ExtraFiles {
+		var origin string
+		switch {
+		case file.Url != "":
+			origin = "download " + planURL(file.Url, DownloadSchemes) + planMirrors(file.Mirrors, DownloadSchemes)
+		case file.Base64 != "":
+			origin = "write base64 content"
+		default:
+			origin = "write content"
+		}
+		steps = append(steps, fmt.Sprintf("%s to %s (mode %04o)%s", origin, file.Path, file.mode, planConflict(serverFolderPath, file.Path, file.Overwrite)))
+	}
+	if len(envs.filesInit.TemplatePatterns) > 0 {
+		steps = append(steps, "render templates matching "+strings.Join(envs.filesInit.TemplatePatterns, ", "))
+	}
+	for _, patch := range envs.filesInit.ConfigPatches {
+		keys := make([]string, 0, len(patch.sets()))
+		for _, set := range patch.sets() {
+			keys = append(keys, joinKeyPath(set.path))
+		}
+		steps = append(steps, fmt.Sprintf("patch %s config %s: %s", patch.Format, patch.Path, strings.Join(keys, ", ")))
+	}
+
+	if envs.filesInit.JvmArgsFile != "" {
+		steps = append(steps, "write JVM args sized from the memory limit to "+envs.filesInit.JvmArgsFile+planConflict(serverFolderPath, envs.filesInit.JvmArgsFile, ConflictPolicyOverwrite))
+	}
+	startScriptName := envs.startScriptName
+	if startScriptName == "" && envs.filesInit.CustomStartScript != "" {
+		startScriptName = GeneratedStartScriptName
+	}
+	if startScriptName == "" {
+		steps = append(steps, "detect start script from "+strings.Join(KnownStartScripts, ", ")+", or generate "+GeneratedStartScriptName+" for the Forge libraries, Fabric launcher or server jar")
+	} else if envs.filesInit.CustomStartScript == "" {
+		steps = append(steps, "make start script "+startScriptName+" executable, or detect or generate one when it is not deployed")
+	}
+	steps = append(steps, "record the chosen start script in "+StartScriptRecordPath)
+	if envs.filesInit.CustomStartScript != "" {
+		steps = append(steps, "write custom start script to "+startScriptName+planConflict(serverFolderPath, startScriptName, ConflictPolicyOverwrite))
+	}
+	steps = append(steps, "write eula.txt"+planConflict(serverFolderPath, "eula.txt", ConflictPolicyOverwrite))
+	if envs.filesInit.ServerIconUrl != "" {
+		steps = append(steps, "download "+planURL(envs.filesInit.ServerIconUrl, DownloadSchemes)+planMirrors(envs.filesInit.ServerIconMirrors, DownloadSchemes)+" to server-icon.png"+planConflict(serverFolderPath, "server-icon.png", ConflictPolicyOverwrite))
+	}
+	steps = append(steps, "write server.properties"+planConflict(serverFolderPath, "server.properties", ConflictPolicyOverwrite))
+	if envs.filesInit.EnableRcon {
+		origin := "configured"
+		if envs.filesInit.RconPassword == "" {
+			origin = "generated or reused"
+		}
+		steps = append(steps, "write "+origin+" RCON password to "+envs.filesInit.RconPasswordPath+" (mode 0600)")
+	}
+	if envs.filesInit.SanitiseScripts {
+		steps = append(steps, "sanitise line endings, shebang and exec bit of the start script and "+strings.Join(envs.filesInit.scriptPatterns(), ", "))
+	}
+	if envs.filesInit.NormalisePermissions {
+		steps = append(steps, "set directories to mode "+envs.filesInit.DirMode+" and files to mode "+envs.filesInit.FileMode+", executables get an exec bit")
+	}
+	if envs.filesInit.changesOwner() {
+		uid, gid := envs.filesInit.owner()
+		steps = append(steps, fmt.Sprintf("change the owner of the server folder to %d:%d", uid, gid))
+	}
+
+	fmt.Fprintf(w, "Plan for %s:\n", serverFolderPath)
+	for i, step := range steps {
+		fmt.Fprintf(w, "%3d. %s\n", i+1, step)
+	}
+}
+
+// planSource describes how a source is fetched and merged, its urls are resolved the way the fetchers resolve them
+func planSource(source deploymentSource, serverFolderPath string) string {
+	schemes := DeploymentSchemes[source.Type]
+	origin := planURL(source.Url, schemes) + planMirrors(source.Mirrors, schemes)
+
+	// How the download is checked and authenticated applies to every type
+	var details []string
+	if source.Hash != "" {
+		algorithm, _, _ := strings.Cut(source.Hash, ":")
+		details = append(details, "verify "+algorithm+" hash")
+	}
+	if source.Auth != nil {
+		details = append(details, "authenticate with "+source.Auth.String())
+	}
+
+	switch source.Type {
+	case DeploymentTypeZip:
+		stripComponents := "auto"
+		if source.StripComponents != nil {
+			stripComponents = strconv.Itoa(*source.StripComponents)
+		}
+		details = append(details, "strip components "+stripComponents)
+		if source.Subpath != "" {
+			details = append(details, "subpath "+source.Subpath)
+		}
+	case DeploymentTypeFile:
+		filePath := path.Join(source.Target, source.FileName)
+		step := "download " + origin + " to " + filePath + planConflict(serverFolderPath, filePath, source.Conflict)
+		if len(details) > 0 {
+			step += " (" + strings.Join(details, ", ") + ")"
+		}
+		return step
+	}
+	if len(source.Include) > 0 {
+		details = append(details, "include "+strings.Join(source.Include, ", "))
+	}
+	if len(source.Exclude) > 0 {
+		details = append(details, "exclude "+strings.Join(source.Exclude, ", "))
+	}
+	details = append(details, planConflictPolicy(source.Conflict))
+
+	action := "download and extract archive "
+	if source.Type == DeploymentTypeGit {
+		action = "clone git repository "
+	}
+	return action + origin + " into " + source.Target + " (" + strings.Join(details, ", ") + ")"
+}
+
+// planMirrors describes the mirrors tried after a url, empty if there are none
+func planMirrors(mirrors []string, schemes []string) string {
+	if len(mirrors) == 0 {
+		return ""
+	}
+	described := make([]string, len(mirrors))
+	for i, mirror := range mirrors {
+		described[i] = planURL(mirror, schemes)
+	}
+	return " (or mirrors " + strings.Join(described, ", ") + ")"
+}
+
+// planURL redacts a url and resolves it like its fetcher would, so scp-like git urls are shown as ssh urls
+func planURL(rawUrl string, schemes []string) string {
+	sourceUrl, err := parseSourceURL(rawUrl, schemes)
+	if err != nil {
+		return redactString(rawUrl)
+	}
+	return redactString(sourceUrl.String())
+}
+
+// planConflict describes what happens to a file that already exists in the server folder, empty if it does not
+func planConflict(serverFolderPath string, name string, policy string) string {
+	filePath, err := resolveArchiveMemberPath(serverFolderPath, name)
+	if err != nil {
+		return " (refused: " + err.Error() + ")"
+	}
+	if _, err := os.Lstat(filePath); errors.Is(err, os.ErrNotExist) {
+		return ""
+	}
+	switch policy {
+	case ConflictPolicySkip:
+		return " (exists, kept)"
+	case ConflictPolicyError:
+		return " (exists, fails the deployment)"
+	}
+	return " (exists, overwritten)"
+}
+
+func planConflictPolicy(policy string) string {
+	switch policy {
+	case ConflictPolicySkip:
+		return "existing files are kept"
+	case ConflictPolicyError:
+		return "existing files fail the deployment"
+	}
+	return "existing files are overwritten"
+}
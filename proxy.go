@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// ProxySchemes are the proxy protocols net/http can talk to
+var ProxySchemes = []string{"http", "https", "socks5"}
+
+// downloadProxy picks the proxy of a request, by default from HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+var downloadProxy = http.ProxyFromEnvironment
+
+// DownloadRootCAs are the certificate authorities servers are verified against, nil uses the system roots
+var DownloadRootCAs *x509.CertPool
+
+// parseProxy checks an explicit proxy url
+func parseProxy(proxy string) (*url.URL, error) {
+	proxyUrl, err := url.Parse(proxy)
+	if err != nil {
+		return nil, err
+	}
+	if !checkStringMatches(proxyUrl.Scheme, ProxySchemes) || proxyUrl.Host == "" {
+		return nil, errors.New("invalid proxy " + strconv.Quote(redactString(proxy)) + ", expected a url with one of the schemes http, https and socks5")
+	}
+	return proxyUrl, nil
+}
+
+// useProxy routes downloads and clones through proxy instead of the one in HTTP_PROXY and HTTPS_PROXY, hosts in
+// NO_PROXY still bypass it. An empty proxy goes back to the environment
+func useProxy(proxy string) {
+	downloadProxy = http.ProxyFromEnvironment
+	if proxy == "" {
+		return
+	}
+	proxyUrl, err := parseProxy(proxy)
+	if err != nil {
+		panic(err.Error())
+	}
+	if password, ok := proxyUrl.User.Password(); ok {
+		registerSecret(password)
+	}
+	config := httpproxy.Config{HTTPProxy: proxyUrl.String(), HTTPSProxy: proxyUrl.String(), NoProxy: os.Getenv("NO_PROXY")}
+	if config.NoProxy == "" {
+		config.NoProxy = os.Getenv("no_proxy")
+	}
+	proxyFunc := config.ProxyFunc()
+	downloadProxy = func(r *http.Request) (*url.URL, error) {
+		return proxyFunc(r.URL)
+	}
+	slog.Info("Using proxy " + redactString(proxy) + " for downloads")
+}
+
+// newTransport returns a transport that uses the proxy and presents the client certificate of auth, auth may be nil
+func newTransport(auth *downloadAuth) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = downloadProxy
+	transport.TLSClientConfig = &tls.Config{RootCAs: DownloadRootCAs}
+	if auth != nil && auth.certificate != nil {
+		transport.TLSClientConfig.Certificates = []tls.Certificate{*auth.certificate}
+	}
+	return transport
+}
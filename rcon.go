@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// rconPassword returns the configured RCON password, or generates one and keeps it in the password file. A password
+// generated on an earlier start is reused, so restarting the server does not lock out the sidecar
+func rconPassword(filesInit filesInit, serverFolderPath string) string {
+	passwordPath := filesInit.RconPasswordPath
+	if !path.IsAbs(passwordPath) {
+		var err error
+		passwordPath, err = resolveArchiveMemberPath(serverFolderPath, passwordPath)
+		if err != nil {
+			panic("Refusing to write RCON password file: " + err.Error())
+		}
+	}
+
+	password := filesInit.RconPassword
+	if password == "" {
+		existing, err := ServerFiles.ReadFile(passwordPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			panic("Failed to read RCON password file " + passwordPath + ": " + err.Error())
+		}
+		password = strings.TrimRight(string(existing), "\r\n")
+		if password == "" {
+			password = generatePassword()
+			slog.Info("Generated RCON password, writing it to " + passwordPath)
+		} else {
+			slog.Info("Reusing RCON password from " + passwordPath)
+		}
+	}
+	registerSecret(password)
+
+	passwordDir := filepath.Dir(passwordPath)
+	_, err := ServerFiles.Lstat(passwordDir)
+	createdDir := errors.Is(err, os.ErrNotExist)
+	if err := ServerFiles.MkdirAll(passwordDir, 0700); err != nil {
+		panic("Failed to create directory for RCON password file: " + err.Error())
+	}
+	if err := ServerFiles.WriteFile(passwordPath, []byte(password+"\n"), 0600); err != nil {
+		panic("Failed to write RCON password file " + passwordPath + ": " + err.Error())
+	}
+	// An existing file keeps its mode when written, but the password must only be readable by its owner
+	if err := ServerFiles.Chmod(passwordPath, 0600); err != nil {
+		panic("Failed to change file permission for RCON password file " + passwordPath + ": " + err.Error())
+	}
+	// A password file outside the server folder is not reached by applyOwnership, but the owner still has to read it
+	if filesInit.changesOwner() {
+		uid, gid := filesInit.owner()
+		paths := []string{passwordPath}
+		if createdDir {
+			paths = append(paths, passwordDir)
+		}
+		for _, ownedPath := range paths {
+			if err := ServerFiles.Lchown(ownedPath, uid, gid); err != nil {
+				panic("Failed to change owner of RCON password file " + ownedPath + ": " + err.Error())
+			}
+		}
+	}
+	return password
+}
+
+// generatePassword returns 192 random bits encoded without characters that need escaping in server.properties
+func generatePassword() string {
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		panic("Failed to generate password: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(random)
+}
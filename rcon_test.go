@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/magiconair/properties"
+)
+
+func loadTestProperties(t *testing.T, serverDir string) *properties.Properties {
+	loaded, err := properties.LoadFile(path.Join(serverDir, "server.properties"), properties.UTF8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loaded
+}
+
+func TestGeneratedRconPassword(t *testing.T) {
+	serverDir := t.TempDir()
+	filesInit := filesInitDefault
+	filesInit.EnableRcon = true
+	filesInit.EnableQuery = true
+
+	initServerFiles(filesInit, "startserver.sh", serverDir)
+
+	serverProperties := loadTestProperties(t, serverDir)
+	password := serverProperties.GetString("rcon.password", "")
+	if len(password) < 32 {
+		t.Fatalf("Expected a generated password of at least 32 characters but got %q", password)
+	}
+	if serverProperties.GetString("enable-rcon", "") != "true" || serverProperties.GetString("rcon.port", "") != "25575" || serverProperties.GetString("query.port", "") != "25565" {
+		t.Fatalf("Expected RCON and query settings in server.properties but got %v", serverProperties.Map())
+	}
+
+	passwordPath := path.Join(serverDir, ".omsms", "rcon-password")
+	expectFileContent(t, passwordPath, password+"\n")
+	info, err := os.Stat(passwordPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected the password file to have mode 0600 but got %v", info.Mode().Perm())
+	}
+
+	initServerFiles(filesInit, "startserver.sh", serverDir)
+	if reused := loadTestProperties(t, serverDir).GetString("rcon.password", ""); reused != password {
+		t.Fatalf("Expected the generated password to be reused but got %q", reused)
+	}
+	if redacted := redactString("rcon.password=" + password); strings.Contains(redacted, password) {
+		t.Fatalf("Expected the generated password to be redacted but got %s", redacted)
+	}
+}
+
+func TestConfiguredRconPassword(t *testing.T) {
+	serverDir := t.TempDir()
+	filesInit := filesInitDefault
+	filesInit.EnableRcon = true
+	filesInit.RconPassword = "quack-quack"
+	filesInit.RconPasswordPath = path.Join(t.TempDir(), "shared", "rcon")
+
+	initServerFiles(filesInit, "startserver.sh", serverDir)
+
+	if password := loadTestProperties(t, serverDir).GetString("rcon.password", ""); password != "quack-quack" {
+		t.Fatalf("Expected the configured password but got %q", password)
+	}
+	expectFileContent(t, filesInit.RconPasswordPath, "quack-quack\n")
+}
+
+func TestRconPasswordOutsideServerFolderChangesOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Changing the owner needs root")
+	}
+	serverDir := t.TempDir()
+	filesInit := filesInitDefault
+	filesInit.EnableRcon = true
+	filesInit.RconPasswordPath = path.Join(t.TempDir(), "shared", "rcon")
+	owner := int64(1000)
+	filesInit.OwnerUid = &owner
+	filesInit.OwnerGid = &owner
+
+	initServerFiles(filesInit, "startserver.sh", serverDir)
+
+	for _, name := range []string{filesInit.RconPasswordPath, path.Dir(filesInit.RconPasswordPath)} {
+		info, err := os.Lstat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stat := info.Sys().(*syscall.Stat_t)
+		if stat.Uid != 1000 || stat.Gid != 1000 {
+			t.Fatalf("Expected %s to be owned by 1000:1000 but got %d:%d", name, stat.Uid, stat.Gid)
+		}
+	}
+}
+
+func TestDisabledRcon(t *testing.T) {
+	serverDir := t.TempDir()
+	initServerFiles(filesInitDefault, "startserver.sh", serverDir)
+
+	serverProperties := loadTestProperties(t, serverDir)
+	if _, ok := serverProperties.Get("rcon.password"); ok {
+		t.Fatal("Expected no RCON password when RCON is disabled")
+	}
+	if _, err := os.Stat(path.Join(serverDir, ".omsms")); err == nil {
+		t.Fatal("Expected no password file when RCON is disabled")
+	}
+}
+
+func TestRconPasswordIsEscaped(t *testing.T) {
+	serverDir := t.TempDir()
+	filesInit := filesInitDefault
+	filesInit.EnableRcon = true
+	filesInit.RconPassword = ` qu\ack:=#!ducky ü`
+
+	initServerFiles(filesInit, "startserver.sh", serverDir)
+
+	if password := loadTestProperties(t, serverDir).GetString("rcon.password", ""); password != filesInit.RconPassword {
+		t.Fatalf("Expected the server to read %q but got %q", filesInit.RconPassword, password)
+	}
+	expectFileContent(t, path.Join(serverDir, ".omsms", "rcon-password"), filesInit.RconPassword+"\n")
+}
+
+func TestValidateDisabledRconAndQueryPorts(t *testing.T) {
+	filesInit := filesInitDefault
+	filesInit.RconPort = 0
+	filesInit.QueryPort = 0
+	expectValid(t, validateFilesInit, filesInit)
+
+	filesInit.EnableRcon = true
+	expectInvalid(t, validateFilesInit, filesInit, "RconPort")
+	filesInit.EnableRcon = false
+	filesInit.EnableQuery = true
+	expectInvalid(t, validateFilesInit, filesInit, "QueryPort")
+}
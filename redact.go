@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const Redacted = "REDACTED"
+
+// secretFields marks the config fields whose values must never show up in logs, keyed by struct type and field name.
+// Secret fields can also be read from a file named by the field with a File suffix
+var secretFields = map[string]bool{
+	"filesInit.CustomStartScript": true,
+	"filesInit.RconPassword":      true,
+	"extraFile.Content":           true,
+	"extraFile.Base64":            true,
+	"downloadAuth.HeaderValue":    true,
+	"downloadAuth.Password":       true,
+}
+
+// secretEnvs marks the environment variables whose values are secrets when read from a file named by the variable
+// with a _FILE suffix. The others, like OMSMS_SERVER_DEPLOYMENT_TYPE, may be read from files too but are not secret
+var secretEnvs = map[string]bool{
+	"OMSMS_SERVER_FILES_INIT":         true,
+	"OMSMS_SERVER_DEPLOYMENT_VALUE":   true,
+	"OMSMS_SERVER_DEPLOYMENT_MIRRORS": true,
+	"OMSMS_SERVER_DEPLOYMENT_AUTH":    true,
+	"OMSMS_PROXY":                     true,
+}
+
+func isSecretField(structType reflect.Type, field string) bool {
+	return secretFields[structType.Name()+"."+field]
+}
+
+// sensitiveQueryParams are redacted from logged urls when a query parameter name contains one of them
+var sensitiveQueryParams = []string{"token", "key", "sig", "secret", "password", "credential"}
+
+var urlPattern = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.\-]*://[^\s"'<>\\]+`)
+
+// secretValues holds the values of secret config fields, they are replaced wherever they show up in a log
+var secretValues struct {
+	sync.RWMutex
+	values []string
+}
+
+func registerSecret(value string) {
+	if value == "" {
+		return
+	}
+	// Secrets are also logged as part of the raw JSON config, where they appear escaped
+	encoded, _ := json.Marshal(value)
+	secretValues.Lock()
+	defer secretValues.Unlock()
+	for _, secret := range []string{value, string(encoded[1 : len(encoded)-1])} {
+		if !slices.Contains(secretValues.values, secret) {
+			secretValues.values = append(secretValues.values, secret)
+		}
+	}
+	// Longer secrets go first, so a secret containing another one is replaced as a whole
+	slices.SortFunc(secretValues.values, func(a string, b string) int {
+		return len(b) - len(a)
+	})
+}
+
+// registerSecrets registers the values of every secret field in value
+func registerSecrets(value reflect.Value) {
+	switch value.Kind() {
+	case reflect.Pointer:
+		if !value.IsNil() {
+			registerSecrets(value.Elem())
+		}
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			registerSecrets(value.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			if field.Type.Kind() == reflect.String && isSecretField(value.Type(), field.Name) {
+				registerSecret(value.Field(i).String())
+			} else {
+				registerSecrets(value.Field(i))
+			}
+		}
+	}
+}
+
+// redactString removes registered secrets, url credentials and sensitive query parameters from a log string
+func redactString(s string) string {
+	secretValues.RLock()
+	for _, secret := range secretValues.values {
+		s = strings.ReplaceAll(s, secret, Redacted)
+	}
+	secretValues.RUnlock()
+	return urlPattern.ReplaceAllStringFunc(s, redactURL)
+}
+
+func redactURL(rawURL string) string {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	if parsedURL.User != nil {
+		parsedURL.User = url.User(Redacted)
+	}
+	if parsedURL.RawQuery != "" {
+		params := strings.Split(parsedURL.RawQuery, "&")
+		for i, param := range params {
+			name, _, _ := strings.Cut(param, "=")
+			if isSensitiveName(name) {
+				params[i] = name + "=" + Redacted
+			}
+		}
+		parsedURL.RawQuery = strings.Join(params, "&")
+	}
+	return parsedURL.String()
+}
+
+func isSensitiveName(name string) bool {
+	name = strings.ToLower(name)
+	for _, sensitive := range sensitiveQueryParams {
+		if strings.Contains(name, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactingHandler redacts the message and attributes of every record before passing it on
+type redactingHandler struct {
+	handler slog.Handler
+}
+
+func newRedactingHandler(handler slog.Handler) *redactingHandler {
+	return &redactingHandler{handler: handler}
+}
+
+func (h *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, redactString(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(attr))
+		return true
+	})
+	return h.handler.Handle(ctx, redacted)
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		redacted[i] = redactAttr(attr)
+	}
+	return &redactingHandler{handler: h.handler.WithAttrs(redacted)}
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{handler: h.handler.WithGroup(name)}
+}
+
+func redactAttr(attr slog.Attr) slog.Attr {
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, redactString(value.String()))
+	case slog.KindGroup:
+		group := value.Group()
+		redacted := make([]any, len(group))
+		for i, groupAttr := range group {
+			redacted[i] = redactAttr(groupAttr)
+		}
+		return slog.Group(attr.Key, redacted...)
+	case slog.KindAny:
+		return slog.String(attr.Key, redactString(fmt.Sprint(value.Any())))
+	}
+	return attr
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const ScriptShebang = "#!/usr/bin/env bash"
+
+// DefaultScriptPatterns select the scripts that are sanitised when ScriptPatterns is empty
+var DefaultScriptPatterns = []string{"*.sh"}
+
+// interactivePrompt matches a pause or a read without a timeout at the start of a command, both wait for input that
+// never comes in a container
+var interactivePrompt = regexp.MustCompile(`(?:^|[;&|]\s*)(pause|read)(?:\s|$)`)
+
+var utf8Bom = []byte{0xEF, 0xBB, 0xBF}
+
+// scriptSanitation counts what sanitiseScripts changed for the summary
+type scriptSanitation struct {
+	scripts    int
+	lineEnding int
+	shebang    int
+	executable int
+	prompts    int
+}
+
+// scriptPatterns returns the globs of the scripts to sanitise, the defaults when none are configured
+func (filesInit filesInit) scriptPatterns() []string {
+	if len(filesInit.ScriptPatterns) == 0 {
+		return DefaultScriptPatterns
+	}
+	return filesInit.ScriptPatterns
+}
+
+// sanitiseScripts converts the selected shell scripts and the start script to LF line endings, adds a shebang where it
+// is missing and makes them executable. Interactive prompts are only reported, removing them could change what the
+// script does
+func sanitiseScripts(filesInit filesInit, startScriptName string, serverFolderPath string) {
+	if !filesInit.SanitiseScripts {
+		return
+	}
+	filter, err := newFileFilter(filesInit.scriptPatterns(), nil)
+	if err != nil {
+		panic("Invalid script patterns: " + err.Error())
+	}
+	var summary scriptSanitation
+	walkServerFiles(serverFolderPath, "", func(name string, info os.FileInfo) {
+		if name == startScriptName || filter.allows(name) {
+			sanitiseScript(path.Join(serverFolderPath, name), name, info, &summary)
+		}
+	})
+	slog.Info(fmt.Sprintf("Sanitised %d scripts: %d converted to LF line endings, %d given a shebang, %d made executable, %d interactive prompts found",
+		summary.scripts, summary.lineEnding, summary.shebang, summary.executable, summary.prompts))
+}
+
+func sanitiseScript(scriptPath string, name string, info os.FileInfo, summary *scriptSanitation) {
+	content, err := ServerFiles.ReadFile(scriptPath)
+	if err != nil {
+		panic("Failed to read script " + scriptPath + ": " + err.Error())
+	}
+	summary.scripts++
+
+	sanitised := bytes.TrimPrefix(content, utf8Bom)
+	if bytes.Contains(sanitised, []byte("\r")) {
+		sanitised = bytes.ReplaceAll(sanitised, []byte("\r\n"), []byte("\n"))
+		sanitised = bytes.ReplaceAll(sanitised, []byte("\r"), []byte("\n"))
+		summary.lineEnding++
+		slog.Info("Converted " + name + " to LF line endings")
+	}
+	if !bytes.HasPrefix(sanitised, []byte("#!")) {
+		sanitised = append([]byte(ScriptShebang+"\n"), sanitised...)
+		summary.shebang++
+		slog.Info("Added shebang to " + name)
+	}
+	if !bytes.Equal(content, sanitised) {
+		if err := ServerFiles.WriteFile(scriptPath, sanitised, info.Mode().Perm()); err != nil {
+			panic("Failed to write script " + scriptPath + ": " + err.Error())
+		}
+	}
+
+	if info.Mode().Perm()&0111 != 0111 {
+		if err := ServerFiles.Chmod(scriptPath, info.Mode().Perm()|0111); err != nil {
+			panic("Failed to change file permission for script " + scriptPath + ": " + err.Error())
+		}
+		summary.executable++
+		slog.Info(fmt.Sprintf("Made %s executable", name))
+	}
+
+	for i, line := range strings.Split(string(sanitised), "\n") {
+		command := strings.TrimSpace(line)
+		if strings.HasPrefix(command, "#") {
+			continue
+		}
+		if match := interactivePrompt.FindStringSubmatch(command); match != nil && !(match[1] == "read" && strings.Contains(command, "-t")) {
+			summary.prompts++
+			slog.Warn(fmt.Sprintf("%s:%d waits for input with %s, which hangs the server in a container: %s", name, i+1, match[1], command))
+		}
+	}
+}
+
+// walkServerFiles calls fn with every regular file below dir of the server folder, symlinks and .git folders are
+// skipped
+func walkServerFiles(serverFolderPath string, dir string, fn func(name string, info os.FileInfo)) {
+	entries, err := ServerFiles.ReadDir(path.Join(serverFolderPath, dir))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		panic("Failed to read " + path.Join(serverFolderPath, dir) + ": " + err.Error())
+	}
+	for _, entry := range entries {
+		name := path.Join(dir, entry.Name())
+		switch {
+		case entry.IsDir() && entry.Name() != ".git":
+			walkServerFiles(serverFolderPath, name, fn)
+		case entry.Type().IsRegular():
+			info, err := entry.Info()
+			if err != nil {
+				panic("Failed to read " + name + ": " + err.Error())
+			}
+			fn(name, info)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestSanitiseScripts(t *testing.T) {
+	serverDir := writeTestServerFiles(t, map[string]string{
+		"startserver":            "java -jar server.jar\r\nread -p \"Press enter\"\r\n",
+		"scripts/backup.sh":      "#!/bin/sh\ntar czf backup.tgz world\n",
+		"scripts/wait.sh":        "\xEF\xBB\xBFread -t 5 answer\npause\n",
+		"config/notes.txt":       "line\r\n",
+		".git/hooks/pre-push.sh": "exit 1\r\n",
+	})
+	sanitiseScripts(filesInitDefault, "startserver", serverDir)
+
+	expectFileContent(t, path.Join(serverDir, "startserver"), "#!/usr/bin/env bash\njava -jar server.jar\nread -p \"Press enter\"\n")
+	expectFileContent(t, path.Join(serverDir, "scripts/backup.sh"), "#!/bin/sh\ntar czf backup.tgz world\n")
+	expectFileContent(t, path.Join(serverDir, "scripts/wait.sh"), "#!/usr/bin/env bash\nread -t 5 answer\npause\n")
+	expectFileContent(t, path.Join(serverDir, "config/notes.txt"), "line\r\n")
+	expectFileContent(t, path.Join(serverDir, ".git/hooks/pre-push.sh"), "exit 1\r\n")
+	for _, name := range []string{"startserver", "scripts/backup.sh", "scripts/wait.sh"} {
+		info, err := os.Stat(path.Join(serverDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0755 {
+			t.Fatalf("Expected %s to be made executable but got %v", name, info.Mode().Perm())
+		}
+	}
+}
+
+func TestSanitiseScriptsWithPatterns(t *testing.T) {
+	serverDir := writeTestServerFiles(t, map[string]string{
+		"start.sh":       "java -jar server.jar\r\n",
+		"tools/fix.bash": "echo fix\r\n",
+	})
+	settings := filesInitDefault
+	settings.ScriptPatterns = []string{"tools/*.bash"}
+
+	sanitiseScripts(expectValid(t, validateFilesInit, settings), "run.sh", serverDir)
+
+	expectFileContent(t, path.Join(serverDir, "start.sh"), "java -jar server.jar\r\n")
+	expectFileContent(t, path.Join(serverDir, "tools/fix.bash"), "#!/usr/bin/env bash\necho fix\n")
+
+	settings.ScriptPatterns = []string{"[sh"}
+	expectInvalid(t, validateFilesInit, settings, "ScriptPatterns")
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+)
+
+const JsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// filesInitDescriptions documents every field of the files init settings, nested fields are keyed by their dotted path
+var filesInitDescriptions = map[string]string{
+	"CustomStartScript":              "Shell script written to the start script path, replaces the deployed start script when set. Rendered with text/template, see TemplatePatterns",
+	"ServerIconUrl":                  "Url of a 64x64 PNG that is downloaded to server-icon.png",
+	"ServerIconMirrors":              "Urls tried in order when ServerIconUrl fails",
+	"ExtraFiles":                     "Files written to the server folder after deployment",
+	"ExtraFiles.Path":                "Path of the file, relative to the server folder",
+	"ExtraFiles.Url":                 "Url the file is downloaded from, with one of the schemes http, https and file",
+	"ExtraFiles.Mirrors":             "Urls tried in order when Url fails, the Hash must match whichever of them answered",
+	"ExtraFiles.Auth":                "Authenticates the requests for Url and Mirrors",
+	"ExtraFiles.Auth.Header":         "Name of a header sent with HeaderValue, like Authorization or PRIVATE-TOKEN",
+	"ExtraFiles.Auth.HeaderValue":    "Value of Header, like Bearer followed by a token",
+	"ExtraFiles.Auth.Username":       "User name sent as basic auth",
+	"ExtraFiles.Auth.Password":       "Password sent as basic auth",
+	"ExtraFiles.Auth.ClientCertPath": "PEM client certificate presented for mutual TLS",
+	"ExtraFiles.Auth.ClientKeyPath":  "PEM private key of ClientCertPath",
+	"ExtraFiles.Hash":                "Checksum of the downloaded file as algorithm:hex, one of md5, sha1, sha256 and sha512",
+	"ExtraFiles.Content":             "Text content of the file",
+	"ExtraFiles.Base64":              "Base64 encoded content of the file",
+	"ExtraFiles.Mode":                "Octal file permission, defaults to 0644",
+	"ExtraFiles.Overwrite":           "What happens when the file already exists, defaults to OVERWRITE",
+	"ConfigPatches":                  "Changes to structured config files in the server folder, applied after extra files",
+	"ConfigPatches.Path":             "Path of the config file, relative to the server folder",
+	"ConfigPatches.Format":           "Format of the config file, defaults to the format matching the file extension",
+	"ConfigPatches.Set":              "Dotted key paths mapped to the values they are set to, a literal dot is escaped as \\.",
+	"ConfigPatches.Merge":            "Document deep merged into the config file, values in Set win",
+	"Motd":                           "Message of the day shown in the server list",
+	"EnableCommandBlock":             "Enables command blocks",
+	"OnlineMode":                     "Checks connecting players against the Minecraft account database",
+	"AllowFlight":                    "Allows flight in survival mode",
+	"MaxTickTime":                    "Milliseconds a tick may take before the watchdog stops the server, -1 disables the watchdog",
+	"MaxPlayers":                     "Maximum number of players online at the same time",
+	"SpawnProtection":                "Radius of the spawn protection, 0 disables it",
+	"ViewDistance":                   "Chunks sent to the client in every direction",
+	"SimulationDistance":             "Chunks around players that are ticked in every direction",
+	"EnableRcon":                     "Enables the RCON remote console",
+	"RconPort":                       "Port RCON listens on",
+	"RconPassword":                   "RCON password, a strong password is generated when it is empty",
+	"RconPasswordPath":               "File the RCON password is written to with mode 0600, relative to the server folder unless absolute",
+	"EnableQuery":                    "Enables the GameSpy4 query protocol",
+	"QueryPort":                      "Port the query protocol listens on",
+	"JvmArgsFile":                    "File the JVM arguments are written to, like user_jvm_args.txt or jvm.args, empty disables them",
+	"HeapPercent":                    "Percentage of the memory left after the reserve that is used as heap",
+	"HeapReserveMb":                  "Megabytes of the memory limit kept for the JVM outside of the heap and the OS",
+	"JvmFlagsPreset":                 "Garbage collector flags added after the heap size",
+	"JvmExtraArgs":                   "Arguments added after the preset",
+	"SanitiseScripts":                "Converts shell scripts to LF line endings, adds a missing shebang and makes them executable",
+	"ScriptPatterns":                 "Globs of the scripts that are sanitised besides the start script, defaults to *.sh",
+	"OwnerUid":                       "User id the server folder is handed to at the end of init, unset or -1 keeps the owner",
+	"OwnerGid":                       "Group id the server folder is handed to at the end of init, unset or -1 keeps the group",
+	"NormalisePermissions":           "Applies DirMode and FileMode to the server folder, except for extra files with a Mode and the RCON password. Files that are already executable keep an exec bit",
+	"DirMode":                        "Octal permission of directories in the server folder",
+	"FileMode":                       "Octal permission of files in the server folder, executables also get an exec bit for every read bit",
+	"ExecutablePatterns":             "Globs of the files made executable besides the start script and sanitised scripts, defaults to *.sh",
+	"TemplatePatterns":               "Globs of the files rendered with text/template, a file ending in .tmpl is rendered to the path without it. Templates can use .JavaArgs, .MemoryMB, .HeapMB, .ServerJar, .ServerFolder and .Env.NAME",
+}
+
+// filesInitEnums lists the accepted values of fields that only take a fixed set of values
+var filesInitEnums = map[string][]string{
+	"ExtraFiles.Overwrite": ConflictPolicies,
+	"ConfigPatches.Format": PatchFormats,
+	"JvmFlagsPreset":       JvmFlagsPresets,
+}
+
+// filesInitRequired lists the fields that must be set in nested objects
+var filesInitRequired = map[string][]string{
+	"ExtraFiles":    {"Path"},
+	"ConfigPatches": {"Path"},
+}
+
+// filesInitSchema generates the JSON Schema of OMSMS_SERVER_FILES_INIT from the filesInit type, so it cannot drift
+// from what the decoder accepts
+func filesInitSchema() map[string]any {
+	schema := typeSchema(reflect.TypeOf(filesInit{}), "")
+	schema["$schema"] = JsonSchemaDialect
+	schema["title"] = "OMSMS_SERVER_FILES_INIT"
+
+	defaults := reflect.ValueOf(filesInitDefault)
+	properties := schema["properties"].(map[string]any)
+	for name, property := range properties {
+		value := defaults.FieldByName(name)
+		if value.IsValid() && value.Kind() != reflect.Slice {
+			property.(map[string]any)["default"] = value.Interface()
+		}
+	}
+	return schema
+}
+
+func typeSchema(t reflect.Type, path string) map[string]any {
+	// Optional objects are described like the object, null is left out like for every other field
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	schema := map[string]any{}
+	if description, ok := filesInitDescriptions[path]; ok {
+		schema["description"] = description
+	}
+	if enum, ok := filesInitEnums[path]; ok {
+		schema["enum"] = enum
+	}
+	if bounds, ok := filesInitRanges[path]; ok {
+		schema["minimum"] = bounds.Min
+		schema["maximum"] = bounds.Max
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		schema["type"] = "string"
+	case reflect.Bool:
+		schema["type"] = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		schema["type"] = "integer"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema["type"] = "integer"
+		if _, ok := schema["minimum"]; !ok {
+			schema["minimum"] = 0
+		}
+	case reflect.Float32, reflect.Float64:
+		schema["type"] = "number"
+	case reflect.Slice:
+		schema["type"] = "array"
+		items := typeSchema(t.Elem(), path)
+		delete(items, "description")
+		schema["items"] = items
+	case reflect.Map:
+		schema["type"] = "object"
+	case reflect.Struct:
+		properties := map[string]any{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.IsExported() {
+				property := typeSchema(field.Type, joinFieldPath(path, field.Name))
+				if isSecretField(t, field.Name) {
+					property["writeOnly"] = true
+					properties[field.Name+"File"] = map[string]any{
+						"type":        "string",
+						"description": "Path of a file holding " + field.Name + ", for secrets mounted as files",
+					}
+				}
+				properties[field.Name] = property
+			}
+		}
+		schema["type"] = "object"
+		schema["properties"] = properties
+		schema["additionalProperties"] = false
+		if required, ok := filesInitRequired[path]; ok {
+			schema["required"] = required
+		}
+	}
+	return schema
+}
+
+// printSchema writes the files init schema as indented JSON
+func printSchema(w io.Writer) {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(filesInitSchema()); err != nil {
+		panic("Failed to write schema: " + err.Error())
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestFilesInitSchemaMatchesStruct(t *testing.T) {
+	schema := filesInitSchema()
+
+	described := map[string]bool{}
+	var checkProperties func(t *testing.T, schema map[string]any, structType reflect.Type, path string)
+	checkProperties = func(t *testing.T, schema map[string]any, structType reflect.Type, path string) {
+		properties := schema["properties"].(map[string]any)
+		if schema["additionalProperties"] != false {
+			t.Fatalf("Expected %s to reject unknown fields", path)
+		}
+		exported := 0
+		for i := 0; i < structType.NumField(); i++ {
+			field := structType.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			exported++
+			fieldPath := joinFieldPath(path, field.Name)
+			property, ok := properties[field.Name].(map[string]any)
+			if !ok {
+				t.Fatalf("Expected schema property for %s", fieldPath)
+			}
+			if property["description"] == nil {
+				t.Fatalf("Expected a description for %s", fieldPath)
+			}
+			described[fieldPath] = true
+			if isSecretField(structType, field.Name) {
+				if _, ok := properties[field.Name+"File"]; !ok {
+					t.Fatalf("Expected schema property for %sFile", fieldPath)
+				}
+				exported++
+			}
+			if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct {
+				checkProperties(t, property["items"].(map[string]any), field.Type.Elem(), fieldPath)
+			}
+			if field.Type.Kind() == reflect.Pointer && field.Type.Elem().Kind() == reflect.Struct {
+				checkProperties(t, property, field.Type.Elem(), fieldPath)
+			}
+		}
+		if len(properties) != exported {
+			t.Fatalf("Expected %d properties in %s but got %d", exported, path, len(properties))
+		}
+	}
+	checkProperties(t, schema, reflect.TypeOf(filesInit{}), "")
+
+	for path := range filesInitDescriptions {
+		if !described[path] {
+			t.Fatalf("Description for %s does not match a field", path)
+		}
+	}
+}
+
+func TestFilesInitSchemaDefaultsAndRanges(t *testing.T) {
+	properties := filesInitSchema()["properties"].(map[string]any)
+
+	defaults := map[string]any{}
+	for name, property := range properties {
+		if value, ok := property.(map[string]any)["default"]; ok {
+			defaults[name] = value
+		}
+	}
+	defaultsJson, err := json.Marshal(defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded filesInit
+	if err := json.Unmarshal(defaultsJson, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, filesInitDefault) {
+		t.Fatalf("Expected schema defaults %v to match %v", decoded, filesInitDefault)
+	}
+
+	for name, bounds := range filesInitRanges {
+		property := properties[name].(map[string]any)
+		if property["minimum"] != bounds.Min || property["maximum"] != bounds.Max {
+			t.Fatalf("Expected %s to range from %d to %d but got %v to %v", name, bounds.Min, bounds.Max, property["minimum"], property["maximum"])
+		}
+	}
+
+	var problems validationErrors
+	validateFilesInit(fieldPrefix("default"), filesInitDefault, &problems)
+	if err := problems.err(); err != nil {
+		t.Fatalf("Expected the defaults to be valid: %v", err)
+	}
+}
+
+func TestPrintSchema(t *testing.T) {
+	var output bytes.Buffer
+	printSchema(&output)
+
+	var schema map[string]any
+	if err := json.Unmarshal(output.Bytes(), &schema); err != nil {
+		t.Fatal(err)
+	}
+	if schema["$schema"] != JsonSchemaDialect {
+		t.Fatalf("Expected the schema dialect but got %v", schema["$schema"])
+	}
+}
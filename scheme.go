@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	SchemeHttp  = "http"
+	SchemeHttps = "https"
+	SchemeFile  = "file"
+	SchemeSsh   = "ssh"
+)
+
+var (
+	// DownloadSchemes are the schemes with a fetcher, every downloaded url uses one of them. Private S3 objects are
+	// downloaded with a presigned https url
+	DownloadSchemes = []string{SchemeHttp, SchemeHttps, SchemeFile}
+	// GitSchemes are the schemes go-git can clone from
+	GitSchemes = []string{SchemeHttp, SchemeHttps, SchemeSsh, SchemeFile}
+	// DeploymentSchemes are the schemes a source of each deployment type accepts
+	DeploymentSchemes = map[string][]string{
+		DeploymentTypeZip:  DownloadSchemes,
+		DeploymentTypeFile: DownloadSchemes,
+		DeploymentTypeGit:  GitSchemes,
+	}
+)
+
+// urlFetcher writes the content at sourceUrl to dst, which is empty. checksum is verified by the caller, fetchers only
+// use it to keep content that does not match out of the download cache
+type urlFetcher func(sourceUrl *url.URL, checksum string, auth *downloadAuth, dst *os.File) error
+
+// urlFetchers maps the download schemes to their fetchers
+var urlFetchers = map[string]urlFetcher{
+	SchemeHttp:  fetchHttpURL,
+	SchemeHttps: fetchHttpURL,
+	SchemeFile:  fetchFileURL,
+}
+
+// scpLikeUrl matches the git@github.com:ducky/pack.git form git accepts for ssh
+var scpLikeUrl = regexp.MustCompile(`^([a-zA-Z0-9._-]+)@([a-zA-Z0-9.-]+|\[[0-9a-fA-F:.]+\]):([^/].*)$`)
+
+// parseSourceURL parses a url and checks that it has one of schemes and everything that scheme needs. The scp-like
+// form of ssh urls is read as ssh://user@host/path
+func parseSourceURL(str string, schemes []string) (*url.URL, error) {
+	if strings.ContainsAny(str, " \t\r\n") {
+		return nil, errors.New("contains whitespace")
+	}
+	if match := scpLikeUrl.FindStringSubmatch(str); match != nil && !strings.Contains(str, "://") {
+		str = "ssh://" + match[1] + "@" + match[2] + "/" + match[3]
+	}
+	sourceUrl, err := url.Parse(str)
+	var urlError *url.Error
+	if errors.As(err, &urlError) {
+		return nil, urlError.Err
+	} else if err != nil {
+		return nil, err
+	}
+
+	if sourceUrl.Scheme == "" {
+		return nil, errors.New("missing scheme, expected one of " + strings.Join(schemes, ", "))
+	}
+	if !checkStringMatches(sourceUrl.Scheme, schemes) {
+		return nil, errors.New("unsupported scheme " + sourceUrl.Scheme + ", expected one of " + strings.Join(schemes, ", "))
+	}
+	if sourceUrl.Opaque != "" {
+		return nil, errors.New("expected " + sourceUrl.Scheme + "://")
+	}
+	switch sourceUrl.Scheme {
+	case SchemeFile:
+		if sourceUrl.Host != "" && sourceUrl.Host != "localhost" {
+			return nil, errors.New("file urls cannot name a host")
+		}
+		if sourceUrl.Path == "" || sourceUrl.Path == "/" {
+			return nil, errors.New("missing file path")
+		}
+	default:
+		if sourceUrl.Hostname() == "" {
+			return nil, errors.New("missing host")
+		}
+	}
+	return sourceUrl, nil
+}
+
+// fetchHttpURL downloads through the download cache when one is configured
+func fetchHttpURL(sourceUrl *url.URL, checksum string, auth *downloadAuth, dst *os.File) error {
+	if downloadCache != nil {
+		return downloadCache.fetch(sourceUrl.String(), checksum, auth, dst)
+	}
+	_, err := fetchURL(sourceUrl.String(), auth, nil, dst)
+	return err
+}
+
+// fetchFileURL copies a file mounted into the container, like a pack on a shared volume
+func fetchFileURL(sourceUrl *url.URL, checksum string, auth *downloadAuth, dst *os.File) error {
+	file, err := os.Open(sourceUrl.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(dst, file)
+	return err
+}
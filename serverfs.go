@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+)
+
+// serverFileSystem applies changes to the server folder. Downloads and extraction happen in temporary files and
+// folders it hands out, which are then moved into the server folder with Rename
+type serverFileSystem interface {
+	Lstat(name string) (os.FileInfo, error)
+	ReadFile(name string) ([]byte, error)
+	ReadDir(name string) ([]os.DirEntry, error)
+	WriteFile(name string, data []byte, perm os.FileMode) error
+	MkdirAll(name string, perm os.FileMode) error
+	// MkdirTemp and CreateTemp create staging space meant to end up in dir
+	MkdirTemp(dir string, pattern string) (string, error)
+	CreateTemp(dir string, pattern string) (*os.File, error)
+	// Rename moves a staged file or folder into the server folder
+	Rename(oldPath string, newPath string) error
+	RemoveAll(name string) error
+	Chmod(name string, mode os.FileMode) error
+	// Lchown changes the owner of name without following symlinks, -1 keeps the uid or gid
+	Lchown(name string, uid int, gid int) error
+}
+
+// ServerFiles is where init writes the server folder, the dry run replaces it with a recording file system
+var ServerFiles serverFileSystem = diskFileSystem{}
+
+// diskFileSystem applies every change directly
+type diskFileSystem struct{}
+
+func (diskFileSystem) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+func (diskFileSystem) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (diskFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (diskFileSystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
+
+func (diskFileSystem) MkdirAll(name string, perm os.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+func (diskFileSystem) MkdirTemp(dir string, pattern string) (string, error) {
+	return os.MkdirTemp(dir, pattern)
+}
+
+func (diskFileSystem) CreateTemp(dir string, pattern string) (*os.File, error) {
+	return os.CreateTemp(dir, pattern)
+}
+
+func (diskFileSystem) Rename(oldPath string, newPath string) error {
+	return os.Rename(oldPath, newPath)
+}
+
+func (diskFileSystem) RemoveAll(name string) error {
+	return os.RemoveAll(name)
+}
+
+func (diskFileSystem) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+func (diskFileSystem) Lchown(name string, uid int, gid int) error {
+	return os.Lchown(name, uid, gid)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	StartScriptConfigured = "CONFIGURED"
+	StartScriptCustom     = "CUSTOM"
+	StartScriptDetected   = "DETECTED"
+	StartScriptGenerated  = "GENERATED"
+)
+
+var (
+	// KnownStartScripts are looked for in the server folder in order when no start script is configured, scripts
+	// shipped by packs come before the run.sh generated by the Forge installer
+	KnownStartScripts = []string{"startserver.sh", "ServerStart.sh", "start.sh", "run.sh"}
+	// GeneratedStartScriptName is where a custom or generated start script is written when no name is configured
+	GeneratedStartScriptName = "start.sh"
+	// StartScriptRecordPath records which start script was chosen and why, relative to the server folder
+	StartScriptRecordPath = ".omsms/start-script.json"
+)
+
+// forgeLibraries are the folders the Forge and NeoForge installers put their versioned unix_args.txt in
+var forgeLibraries = []string{
+	"libraries/net/minecraftforge/forge",
+	"libraries/net/neoforged/neoforge",
+	"libraries/net/neoforged/forge",
+}
+
+// startScript is the start script chosen for the server, Path is relative to the server folder
+type startScript struct {
+	Path   string
+	Method string
+	Reason string
+}
+
+// resolveStartScript picks the start script after the sources are deployed: the configured name, the name for the
+// custom script, a known name found in the server folder, or a script generated from the server files
+func resolveStartScript(envs envs, serverFolderPath string) startScript {
+	var script startScript
+	switch {
+	case envs.filesInit.CustomStartScript != "":
+		// The custom script is written under the configured name, so that name does not have to exist yet
+		name := envs.startScriptName
+		if name == "" {
+			name = GeneratedStartScriptName
+		}
+		script = startScript{Path: name, Method: StartScriptCustom, Reason: "custom start script is configured"}
+	case envs.startScriptName != "" && isServerFile(serverFolderPath, envs.startScriptName):
+		script = startScript{Path: envs.startScriptName, Method: StartScriptConfigured, Reason: "start script name is configured"}
+	default:
+		if envs.startScriptName != "" {
+			slog.Warn("Configured start script " + envs.startScriptName + " does not exist in the server folder, detecting or generating one instead")
+		}
+		script = detectStartScript(serverFolderPath)
+		if script.Path == "" {
+			var content string
+			script, content = generateStartScript(envs.filesInit, serverFolderPath)
+			scriptPath := path.Join(serverFolderPath, script.Path)
+			if err := ServerFiles.WriteFile(scriptPath, []byte(content), 0755); err != nil {
+				panic("Failed to write generated start script " + scriptPath + ": " + err.Error())
+			}
+		}
+	}
+	slog.Info(fmt.Sprintf("Using start script %s (%s: %s)", script.Path, strings.ToLower(script.Method), script.Reason))
+
+	recordPath := path.Join(serverFolderPath, StartScriptRecordPath)
+	record, _ := json.MarshalIndent(script, "", "  ")
+	if err := ServerFiles.MkdirAll(filepath.Dir(recordPath), os.ModePerm); err != nil {
+		panic("Failed to create directory for start script record: " + err.Error())
+	}
+	if err := ServerFiles.WriteFile(recordPath, append(record, '\n'), 0644); err != nil {
+		panic("Failed to write start script record " + recordPath + ": " + err.Error())
+	}
+	return script
+}
+
+// detectStartScript returns the first known start script in the server folder, an empty path if there is none
+func detectStartScript(serverFolderPath string) startScript {
+	for _, name := range KnownStartScripts {
+		if isServerFile(serverFolderPath, name) {
+			return startScript{Path: name, Method: StartScriptDetected, Reason: "found " + name + " in the server folder"}
+		}
+	}
+	return startScript{}
+}
+
+// isServerFile reports whether name is a regular file in the server folder
+func isServerFile(serverFolderPath string, name string) bool {
+	info, err := ServerFiles.Lstat(path.Join(serverFolderPath, name))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// generateStartScript writes a script for a Forge or NeoForge installation, a Fabric server launcher or a single
+// server jar, in that order
+func generateStartScript(filesInit filesInit, serverFolderPath string) (startScript, string) {
+	jvmArgs := ""
+	if filesInit.JvmArgsFile != "" {
+		jvmArgs = " " + shellQuote("@"+filesInit.JvmArgsFile)
+	} else if _, err := ServerFiles.Lstat(path.Join(serverFolderPath, "user_jvm_args.txt")); err == nil {
+		jvmArgs = " @user_jvm_args.txt"
+	}
+
+	var command, reason string
+	if argsFile := findForgeArgsFile(serverFolderPath); argsFile != "" {
+		command = "java" + jvmArgs + " " + shellQuote("@"+argsFile) + " nogui"
+		reason = "found Forge libraries with " + argsFile
+	} else {
+		jar, found, err := findServerJar(serverFolderPath)
+		if err != nil {
+			panic("Failed to generate start script: " + err.Error() + ", set OMSMS_SERVER_START_SCRIPT_NAME")
+		}
+		command = "java" + jvmArgs + " -jar " + shellQuote(jar) + " nogui"
+		reason = "found " + found + " " + jar
+	}
+
+	content := "#!/usr/bin/env bash\n" +
+		"# Generated by omsms-server-init, " + reason + "\n" +
+		"cd \"$(dirname \"$0\")\"\n" +
+		"exec " + command + " \"$@\"\n"
+	return startScript{Path: GeneratedStartScriptName, Method: StartScriptGenerated, Reason: reason}, content
+}
+
+// shellQuote quotes an argument of the generated start script when it contains anything but a plain file name
+func shellQuote(arg string) string {
+	if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789@%+=:,./_-") == "" {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// findForgeArgsFile returns the unix_args.txt of the last installed Forge or NeoForge version in name order, relative
+// to the server folder, empty if there is none
+func findForgeArgsFile(serverFolderPath string) string {
+	for _, libraries := range forgeLibraries {
+		versions, err := ServerFiles.ReadDir(path.Join(serverFolderPath, libraries))
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				panic("Failed to read " + libraries + ": " + err.Error())
+			}
+			continue
+		}
+		for i := len(versions) - 1; i >= 0; i-- {
+			argsFile := path.Join(libraries, versions[i].Name(), "unix_args.txt")
+			if _, err := ServerFiles.Lstat(path.Join(serverFolderPath, argsFile)); err == nil {
+				return argsFile
+			}
+		}
+	}
+	return ""
+}
+
+// findServerJar returns the Fabric server launcher or the only jar in the top level of the server folder, and what
+// kind of jar it is
+func findServerJar(serverFolderPath string) (string, string, error) {
+	entries, err := ServerFiles.ReadDir(serverFolderPath)
+	if err != nil {
+		return "", "", errors.New("failed to read server folder: " + err.Error())
+	}
+	var jars []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), ".jar") {
+			jars = append(jars, entry.Name())
+		}
+	}
+	for _, jar := range jars {
+		if strings.HasPrefix(jar, "fabric-server-") && strings.Contains(jar, "launch") {
+			return jar, "Fabric server launcher", nil
+		}
+	}
+	switch len(jars) {
+	case 0:
+		return "", "", errors.New("none of " + strings.Join(KnownStartScripts, ", ") + " and no Forge libraries or server jar in the server folder")
+	case 1:
+		return jars[0], "server jar", nil
+	}
+	return "", "", errors.New("found several server jars (" + strings.Join(jars, ", ") + ")")
+}
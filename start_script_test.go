@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func writeTestServerFiles(t *testing.T, files map[string]string) string {
+	serverDir := t.TempDir()
+	for name, content := range files {
+		if err := os.MkdirAll(path.Dir(path.Join(serverDir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path.Join(serverDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return serverDir
+}
+
+func TestResolveStartScriptDetectsKnownName(t *testing.T) {
+	serverDir := writeTestServerFiles(t, map[string]string{
+		"run.sh":         "java @user_jvm_args.txt",
+		"ServerStart.sh": "./run.sh",
+		"server.jar":     "jar",
+	})
+
+	script := resolveStartScript(envs{filesInit: filesInitDefault}, serverDir)
+	if script.Path != "ServerStart.sh" || script.Method != StartScriptDetected {
+		t.Fatalf("Expected ServerStart.sh to be detected but got %v", script)
+	}
+
+	var record startScript
+	content, err := os.ReadFile(path.Join(serverDir, StartScriptRecordPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(content, &record); err != nil {
+		t.Fatal(err)
+	}
+	if record != script {
+		t.Fatalf("Expected the record to match %v but got %v", script, record)
+	}
+}
+
+func TestResolveStartScriptGenerates(t *testing.T) {
+	cases := []struct {
+		files   map[string]string
+		command string
+	}{
+		{
+			map[string]string{
+				"user_jvm_args.txt": "-Xmx4G",
+				"libraries/net/minecraftforge/forge/1.20.1-47.2.0/unix_args.txt":  "args",
+				"libraries/net/minecraftforge/forge/1.20.1-47.2.0/forge.jar":      "jar",
+				"libraries/net/minecraftforge/forge/1.20.1-47.3.0/unix_args.txt":  "args",
+				"libraries/net/minecraftforge/forge/1.20.1-47.3.0/forge-shim.jar": "jar",
+			},
+			"exec java @user_jvm_args.txt @libraries/net/minecraftforge/forge/1.20.1-47.3.0/unix_args.txt nogui",
+		},
+		{
+			map[string]string{"fabric-server-mc.1.20.1-loader.0.15.7-launcher.1.0.0.jar": "jar", "server.jar": "jar"},
+			"exec java -jar fabric-server-mc.1.20.1-loader.0.15.7-launcher.1.0.0.jar nogui",
+		},
+		{
+			map[string]string{"paper-1.20.4.jar": "jar", "mods/ducky.jar": "jar"},
+			"exec java -jar paper-1.20.4.jar nogui",
+		},
+	}
+	for _, c := range cases {
+		serverDir := writeTestServerFiles(t, c.files)
+		script := resolveStartScript(envs{filesInit: filesInitDefault}, serverDir)
+		if script.Path != GeneratedStartScriptName || script.Method != StartScriptGenerated {
+			t.Fatalf("Expected a generated start script for %v but got %v", c.files, script)
+		}
+		content, err := os.ReadFile(path.Join(serverDir, script.Path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(content), "#!/usr/bin/env bash\n") || !strings.Contains(string(content), c.command+` "$@"`) {
+			t.Fatalf("Expected the generated script to run %q but got:\n%s", c.command, content)
+		}
+	}
+}
+
+func TestResolveStartScriptUsesJvmArgsFile(t *testing.T) {
+	serverDir := writeTestServerFiles(t, map[string]string{"server.jar": "jar"})
+	filesInit := filesInitDefault
+	filesInit.JvmArgsFile = "jvm_args.txt"
+
+	script := resolveStartScript(envs{filesInit: filesInit}, serverDir)
+	expectFileContent(t, path.Join(serverDir, script.Path), "#!/usr/bin/env bash\n# Generated by omsms-server-init, found server jar server.jar\ncd \"$(dirname \"$0\")\"\nexec java @jvm_args.txt -jar server.jar nogui \"$@\"\n")
+}
+
+func TestResolveStartScriptFailsWithoutServer(t *testing.T) {
+	expectPanic(t, func() {
+		resolveStartScript(envs{filesInit: filesInitDefault}, writeTestServerFiles(t, map[string]string{"a.jar": "jar", "b.jar": "jar"}))
+	})
+	expectPanic(t, func() {
+		resolveStartScript(envs{filesInit: filesInitDefault}, t.TempDir())
+	})
+}
+
+func TestResolveStartScriptWithMissingConfiguredName(t *testing.T) {
+	serverDir := writeTestServerFiles(t, map[string]string{"run.sh": "java @user_jvm_args.txt"})
+
+	script := resolveStartScript(envs{filesInit: filesInitDefault, startScriptName: "startserver.sh"}, serverDir)
+	if script.Path != "run.sh" || script.Method != StartScriptDetected {
+		t.Fatalf("Expected run.sh to be detected instead of the missing startserver.sh but got %v", script)
+	}
+
+	filesInit := filesInitDefault
+	filesInit.CustomStartScript = "java -jar server.jar"
+	script = resolveStartScript(envs{filesInit: filesInit, startScriptName: "startserver.sh"}, serverDir)
+	if script.Path != "startserver.sh" || script.Method != StartScriptCustom {
+		t.Fatalf("Expected the custom script to be written to startserver.sh but got %v", script)
+	}
+}
+
+func TestResolveStartScriptQuotesServerJar(t *testing.T) {
+	serverDir := writeTestServerFiles(t, map[string]string{"ducky's server.jar": "jar"})
+
+	script := resolveStartScript(envs{filesInit: filesInitDefault}, serverDir)
+	content, err := os.ReadFile(path.Join(serverDir, script.Path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), `exec java -jar 'ducky'\''s server.jar' nogui`) {
+		t.Fatalf("Expected the server jar to be quoted but got:\n%s", content)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// openTarReader opens a possibly compressed tar archive, the returned closer releases the file and decompressor
+func openTarReader(archivePath string, format string) (*tar.Reader, func(), error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch format {
+	case ArchiveFormatTar:
+		return tar.NewReader(file), func() { file.Close() }, nil
+	case ArchiveFormatTarGz:
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return tar.NewReader(gzipReader), func() { gzipReader.Close(); file.Close() }, nil
+	case ArchiveFormatTarXz:
+		xzReader, err := xz.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return tar.NewReader(xzReader), func() { file.Close() }, nil
+	case ArchiveFormatTarZst:
+		zstdReader, err := zstd.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return tar.NewReader(zstdReader), func() { zstdReader.Close(); file.Close() }, nil
+	}
+	file.Close()
+	return nil, nil, errors.New("unsupported tar format: " + format)
+}
+
+// walkTarArchive calls fn for every member of the archive, fn may read the member content from the tar reader
+func walkTarArchive(archivePath string, format string, fn func(header *tar.Header, reader *tar.Reader)) {
+	reader, closeReader, err := openTarReader(archivePath, format)
+	if err != nil {
+		panic("Failed to open " + format + " archive: " + err.Error())
+	}
+	defer closeReader()
+
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			panic("Failed to read " + format + " archive: " + err.Error())
+		}
+		fn(header, reader)
+	}
+}
+
+func extractTarArchive(archivePath string, format string, distPath string, options archiveOptions, filter fileFilter) {
+	// Tar archives have no central directory, so the member names are collected in a separate pass
+	var members []archiveMember
+	walkTarArchive(archivePath, format, func(header *tar.Header, _ *tar.Reader) {
+		members = append(members, archiveMember{Name: header.Name, IsDir: header.Typeflag == tar.TypeDir})
+	})
+	mapName := newArchiveNameMapper(members, options)
+
+	filtered := 0
+	walkTarArchive(archivePath, format, func(header *tar.Header, reader *tar.Reader) {
+		name := mapName(header.Name)
+		if name != "." && !filter.allows(name) {
+			slog.Debug("Filtered out tar member " + name)
+			filtered++
+			return
+		}
+		linkname := header.Linkname
+		if header.Typeflag == tar.TypeLink {
+			linkname = mapName(linkname)
+			// The target was not extracted, so there is nothing to link to
+			if linkname == "." || !filter.allows(linkname) {
+				slog.Warn("Skipping hard link " + name + " to " + header.Linkname + ", its target is filtered out")
+				filtered++
+				return
+			}
+		}
+		extractTarEntry(header, name, linkname, reader, distPath)
+	})
+	if filtered > 0 {
+		slog.Info(fmt.Sprintf("Filtered out %d tar members", filtered))
+	}
+}
+
+func extractTarEntry(header *tar.Header, name string, linkname string, reader io.Reader, path string) {
+	if cleanArchiveMemberName(name) == "." {
+		return
+	}
+	filePath, err := resolveArchiveMemberPath(path, name)
+	if err != nil {
+		panic("Refusing to extract tar member: " + err.Error())
+	}
+	slog.Debug("Extracting file from: " + header.Name + " to: " + filePath)
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(filePath, os.ModePerm); err != nil {
+			panic("Failed to create directory: " + err.Error())
+		}
+
+	case tar.TypeReg:
+		prepareTarEntryPath(filePath)
+		dstFile, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, header.FileInfo().Mode().Perm())
+		if err != nil {
+			panic("Failed to open destination file: " + filePath + ", error: " + err.Error())
+		}
+		defer dstFile.Close()
+
+		if _, err := io.Copy(dstFile, reader); err != nil {
+			panic("Failed to write contents to destination file: " + filePath + ", error: " + err.Error())
+		}
+
+	case tar.TypeSymlink:
+		extractSymlink(path, filePath, linkname)
+
+	case tar.TypeLink:
+		// Hard link targets are archive paths, so they get the same checks as regular members
+		targetPath, err := resolveArchiveMemberPath(path, linkname)
+		if err != nil {
+			panic("Refusing to extract tar member: " + err.Error())
+		}
+		targetInfo, err := os.Lstat(targetPath)
+		if err != nil {
+			panic("Failed to find hard link target " + targetPath + " for " + filePath + ", error: " + err.Error())
+		}
+		if !targetInfo.Mode().IsRegular() {
+			panic("Refusing to extract tar member: hard link " + filePath + " does not point to a regular file")
+		}
+		prepareTarEntryPath(filePath)
+		if err := os.RemoveAll(filePath); err != nil {
+			panic("Failed to replace existing file: " + filePath + ", error: " + err.Error())
+		}
+		if err := os.Link(targetPath, filePath); err != nil {
+			panic("Failed to create hard link: " + filePath + ", error: " + err.Error())
+		}
+
+	default:
+		slog.Warn("Skipping unsupported tar member " + header.Name + " of type " + string(header.Typeflag))
+	}
+}
+
+// prepareTarEntryPath creates the parent directories of filePath and removes a symlink sitting at filePath
+func prepareTarEntryPath(filePath string) {
+	if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+		panic("Failed to create directory: " + err.Error())
+	}
+	if err := removeExistingSymlink(filePath); err != nil {
+		panic("Failed to replace existing symlink: " + filePath + ", error: " + err.Error())
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+)
+
+const TemplateSuffix = ".tmpl"
+
+// templateData is what CustomStartScript and template files are rendered with:
+//
+//	{{ .JavaArgs }}      heap size, flags preset and extra args joined by spaces, as written to JvmArgsFile
+//	{{ .MemoryMB }}      memory limit of the container in megabytes
+//	{{ .HeapMB }}        heap size in megabytes computed from HeapPercent and HeapReserveMb
+//	{{ .ServerJar }}     Fabric server launcher or the only jar in the top level of the server folder
+//	{{ .ServerFolder }}  absolute path of the server folder
+//	{{ .Env.NAME }}      environment variable NAME, a variable that is not set fails the rendering
+//
+// The memory and jar values are only looked up when a template uses them
+type templateData struct {
+	filesInit    filesInit
+	ServerFolder string
+	Env          map[string]string
+}
+
+func newTemplateData(filesInit filesInit, serverFolderPath string) templateData {
+	env := map[string]string{}
+	for _, variable := range os.Environ() {
+		name, value, _ := strings.Cut(variable, "=")
+		env[name] = value
+	}
+	return templateData{filesInit: filesInit, ServerFolder: serverFolderPath, Env: env}
+}
+
+func (data templateData) MemoryMB() (uint64, error) {
+	limit, _, err := readMemoryLimit()
+	return limit / 1024 / 1024, err
+}
+
+func (data templateData) HeapMB() (uint64, error) {
+	limit, _, err := readMemoryLimit()
+	if err != nil {
+		return 0, err
+	}
+	return heapSizeMb(limit, data.filesInit.HeapPercent, data.filesInit.HeapReserveMb)
+}
+
+func (data templateData) JavaArgs() (string, error) {
+	heapMb, err := data.HeapMB()
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(jvmArgs(data.filesInit, heapMb), " "), nil
+}
+
+func (data templateData) ServerJar() (string, error) {
+	jar, _, err := findServerJar(data.ServerFolder)
+	return jar, err
+}
+
+// parseTemplate parses text as a template named after the file it comes from, so errors name the file and line
+func parseTemplate(name string, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Parse(text)
+}
+
+// renderTemplate renders text with data, errors read like "template: start.sh:3:12: ..."
+func renderTemplate(name string, text string, data templateData) (string, error) {
+	tmpl, err := parseTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", err
+	}
+	return rendered.String(), nil
+}
+
+// renderTemplateFiles renders every file matching TemplatePatterns. A file ending in .tmpl is rendered to the same
+// path without the suffix and removed, other files are rendered in place
+func renderTemplateFiles(filesInit filesInit, serverFolderPath string) {
+	if len(filesInit.TemplatePatterns) == 0 {
+		return
+	}
+	filter, err := newFileFilter(filesInit.TemplatePatterns, nil)
+	if err != nil {
+		panic("Invalid template patterns: " + err.Error())
+	}
+
+	data := newTemplateData(filesInit, serverFolderPath)
+	var rendered int
+	walkServerFiles(serverFolderPath, "", func(name string, info os.FileInfo) {
+		if !filter.allows(name) {
+			return
+		}
+		templatePath := path.Join(serverFolderPath, name)
+		text, err := ServerFiles.ReadFile(templatePath)
+		if err != nil {
+			panic("Failed to read template " + templatePath + ": " + err.Error())
+		}
+		content, err := renderTemplate(name, string(text), data)
+		if err != nil {
+			panic("Failed to render template: " + err.Error())
+		}
+
+		targetPath := strings.TrimSuffix(templatePath, TemplateSuffix)
+		if targetPath != templatePath {
+			if targetInfo, err := ServerFiles.Lstat(targetPath); err == nil && targetInfo.IsDir() {
+				panic("Failed to render template " + name + ": " + targetPath + " is a directory")
+			} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+				panic("Failed to render template " + name + ": " + err.Error())
+			}
+		}
+		if err := ServerFiles.WriteFile(targetPath, []byte(content), info.Mode().Perm()); err != nil {
+			panic("Failed to write rendered template " + targetPath + ": " + err.Error())
+		}
+		if targetPath != templatePath {
+			if err := ServerFiles.RemoveAll(templatePath); err != nil {
+				panic("Failed to remove template " + templatePath + ": " + err.Error())
+			}
+		}
+		rendered++
+		slog.Info("Rendered template " + name + " to " + strings.TrimPrefix(targetPath, serverFolderPath+"/"))
+	})
+	slog.Info(fmt.Sprintf("Successfully rendered %d templates", rendered))
+}
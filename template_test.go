@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestRenderTemplateFiles(t *testing.T) {
+	setTestMemory(t, map[string]string{"cgroup/memory.max": "4294967296"})
+	t.Setenv("DUCKY_NAME", "Quackers")
+	serverDir := writeTestServerFiles(t, map[string]string{
+		"paper.jar":             "jar",
+		"start.sh.tmpl":         "java {{ .JavaArgs }} -jar {{ .ServerJar }} # {{ .MemoryMB }}M",
+		"config/ducky.yml.tmpl": "name: {{ .Env.DUCKY_NAME }}\nheap: {{ .HeapMB }}\n",
+		"config/plain.yml":      "name: {{ .Env.DUCKY_NAME }}\n",
+	})
+	filesInit := filesInitDefault
+	filesInit.TemplatePatterns = []string{"*.tmpl"}
+
+	renderTemplateFiles(filesInit, serverDir)
+
+	expectFileContent(t, path.Join(serverDir, "start.sh"), "java -Xms2457M -Xmx2457M -jar paper.jar # 4096M")
+	expectFileContent(t, path.Join(serverDir, "config/ducky.yml"), "name: Quackers\nheap: 2457\n")
+	expectFileContent(t, path.Join(serverDir, "config/plain.yml"), "name: {{ .Env.DUCKY_NAME }}\n")
+	if _, err := os.Stat(path.Join(serverDir, "start.sh.tmpl")); !os.IsNotExist(err) {
+		t.Fatalf("Expected start.sh.tmpl to be removed but got %v", err)
+	}
+}
+
+func TestRenderTemplateErrorsNameFileAndLine(t *testing.T) {
+	data := newTemplateData(filesInitDefault, t.TempDir())
+	for text, expected := range map[string]string{
+		"#!/bin/sh\n\necho {{ .Env.OMSMS_TEST_MISSING }}": "start.sh:3:",
+		"#!/bin/sh\necho {{ .Ducky }}":                    "start.sh:2:",
+		"#!/bin/sh\necho {{ .Env.HOME":                    "start.sh:2:",
+	} {
+		_, err := renderTemplate("start.sh", text, data)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("Expected an error at %s but got %v", expected, err)
+		}
+	}
+}
+
+func TestCustomStartScriptTemplate(t *testing.T) {
+	t.Setenv("DUCKY_MOTD", "quack")
+	serverDir := t.TempDir()
+	filesInit := filesInitDefault
+	filesInit.CustomStartScript = "#!/bin/sh\necho {{ .Env.DUCKY_MOTD }} from {{ .ServerFolder }}\n"
+
+	initServerFiles(filesInit, "start.sh", serverDir)
+
+	expectFileContent(t, path.Join(serverDir, "start.sh"), "#!/bin/sh\necho quack from "+serverDir+"\n")
+
+	filesInit.CustomStartScript = "{{ if }}"
+	expectInvalid(t, validateFilesInit, filesInit, "CustomStartScript")
+}
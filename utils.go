@@ -1,14 +1,6 @@
 package main
 
-import "regexp"
-
-func isURL(str string) bool {
-	re, err := regexp.Compile(`^(https?:\/\/[-a-zA-Z0-9@:%._\+~#=]+)((\/[a-zA-Z0-9.\-_~!$&'()*+,;=:@%]+)+)?\/?([-a-zA-Z0-9-._~=&?%]+)?$`)
-	if err != nil {
-		panic("Failed to compile regex " + err.Error())
-	}
-	return re.MatchString(str)
-}
+import "strings"
 
 func checkStringMatches(str string, options []string) bool {
 	for _, option := range options {
@@ -18,3 +10,15 @@ func checkStringMatches(str string, options []string) bool {
 	}
 	return false
 }
+
+// splitList splits a comma separated list, ignoring surrounding whitespace and empty items
+func splitList(str string) []string {
+	var items []string
+	for _, item := range strings.Split(str, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type validationProblem struct {
+	Field  string
+	Reason string
+}
+
+// validationErrors collects every problem found in the configuration, so they can be fixed in one go
+type validationErrors []validationProblem
+
+func (problems *validationErrors) add(field string, reason string) {
+	*problems = append(*problems, validationProblem{Field: field, Reason: reason})
+}
+
+func (problems validationErrors) Error() string {
+	var report strings.Builder
+	if len(problems) == 1 {
+		report.WriteString("Found 1 configuration problem:")
+	} else {
+		report.WriteString(fmt.Sprintf("Found %d configuration problems:", len(problems)))
+	}
+	for _, problem := range problems {
+		report.WriteString("\n  " + problem.Field + ": " + problem.Reason)
+	}
+	return report.String()
+}
+
+// err returns nil when no problems were found
+func (problems validationErrors) err() error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return problems
+}
+
+// fieldNamer turns a struct field name into the path reported to the operator
+type fieldNamer func(field string) string
+
+func fieldPrefix(prefix string) fieldNamer {
+	return func(field string) string {
+		return joinFieldPath(prefix, field)
+	}
+}
+
+// joinFieldPath appends a field to a path, an empty path stands for the document root
+func joinFieldPath(path string, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+// decodeStrict decodes JSON into target and reports unknown fields and type mismatches under field. It returns the
+// generic document so callers can tell which fields were set, nil when data is not valid JSON at all
+func decodeStrict(field string, data []byte, target any, problems *validationErrors) any {
+	var document any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// Numbers are kept as written, so they survive encoding the document again after reading secret files
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		problems.add(field, "invalid JSON: "+err.Error())
+		return nil
+	}
+	if readSecretFiles(document, reflect.TypeOf(target).Elem(), field, problems) {
+		data, _ = json.Marshal(document)
+	}
+	for _, unknown := range findUnknownFields(document, reflect.TypeOf(target).Elem(), field) {
+		problems.add(unknown, "unknown field")
+	}
+
+	err := json.Unmarshal(data, target)
+	var typeError *json.UnmarshalTypeError
+	if errors.As(err, &typeError) {
+		problems.add(joinFieldPath(field, typeError.Field), fmt.Sprintf("expected %s but got %s", typeError.Type, typeError.Value))
+	} else if err != nil {
+		problems.add(field, err.Error())
+	}
+	return document
+}
+
+// hasField reports whether a decoded JSON object sets field, matching case-insensitively like encoding/json
+func hasField(document any, field string) bool {
+	object, _ := document.(map[string]any)
+	for key := range object {
+		if strings.EqualFold(key, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// readSecretFiles replaces every secret field set with a File suffix by the content of the named file, the way
+// container images read secrets mounted as files. It reports whether the document changed
+func readSecretFiles(document any, target reflect.Type, path string, problems *validationErrors) bool {
+	for target.Kind() == reflect.Pointer {
+		target = target.Elem()
+	}
+
+	changed := false
+	switch value := document.(type) {
+	case map[string]any:
+		if target.Kind() != reflect.Struct {
+			return false
+		}
+		for _, key := range sortedKeys(value) {
+			if len(key) <= len("File") || !strings.EqualFold(key[len(key)-len("File"):], "File") {
+				continue
+			}
+			field, found := target.FieldByNameFunc(func(name string) bool {
+				return strings.EqualFold(name, key[:len(key)-len("File")])
+			})
+			if !found || !isSecretField(target, field.Name) {
+				continue
+			}
+			if hasField(value, field.Name) {
+				problems.add(joinFieldPath(path, key), "set either "+field.Name+" or "+key)
+				continue
+			}
+			fileName, _ := value[key].(string)
+			content, err := readSecretFile(fileName)
+			if err != nil {
+				problems.add(joinFieldPath(path, key), err.Error())
+				continue
+			}
+			delete(value, key)
+			value[field.Name] = content
+			changed = true
+		}
+		for key, item := range value {
+			field, found := target.FieldByNameFunc(func(name string) bool {
+				return strings.EqualFold(name, key)
+			})
+			if found && field.IsExported() && readSecretFiles(item, field.Type, joinFieldPath(path, field.Name), problems) {
+				changed = true
+			}
+		}
+	case []any:
+		if target.Kind() != reflect.Slice {
+			return false
+		}
+		for i, item := range value {
+			if readSecretFiles(item, target.Elem(), fmt.Sprintf("%s[%d]", path, i), problems) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// readSecretFile reads a mounted secret, trailing newlines added by editors and secret stores are removed
+func readSecretFile(fileName string) (string, error) {
+	if fileName == "" {
+		return "", errors.New("secret file name is empty")
+	}
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return "", errors.New("failed to read secret file: " + err.Error())
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// findUnknownFields walks a decoded JSON document alongside the type it is decoded into, returning the paths of
+// object keys that match no exported field. Keys match case-insensitively like in encoding/json
+func findUnknownFields(document any, target reflect.Type, path string) []string {
+	for target.Kind() == reflect.Pointer {
+		target = target.Elem()
+	}
+
+	var unknown []string
+	switch value := document.(type) {
+	case map[string]any:
+		if target.Kind() != reflect.Struct {
+			return nil
+		}
+		for _, key := range sortedKeys(value) {
+			field, found := target.FieldByNameFunc(func(name string) bool {
+				return strings.EqualFold(name, key)
+			})
+			if !found || !field.IsExported() {
+				unknown = append(unknown, joinFieldPath(path, key))
+				continue
+			}
+			unknown = append(unknown, findUnknownFields(value[key], field.Type, joinFieldPath(path, field.Name))...)
+		}
+	case []any:
+		if target.Kind() != reflect.Slice {
+			return nil
+		}
+		for i, item := range value {
+			unknown = append(unknown, findUnknownFields(item, target.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	return unknown
+}
+
+type valueRange struct {
+	Min int64
+	Max int64
+}
+
+// checkRange reports an integer value outside of bounds, unsigned values are compared without converting them
+func checkRange(field string, value reflect.Value, bounds valueRange, problems *validationErrors) {
+	// An unset optional setting has no value to check
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return
+		}
+		value = value.Elem()
+	}
+	var outOfRange bool
+	var formatted string
+	switch {
+	case value.CanInt():
+		outOfRange = value.Int() < bounds.Min || value.Int() > bounds.Max
+		formatted = strconv.FormatInt(value.Int(), 10)
+	case value.CanUint():
+		outOfRange = bounds.Min > 0 && value.Uint() < uint64(bounds.Min) || bounds.Max >= 0 && value.Uint() > uint64(bounds.Max)
+		formatted = strconv.FormatUint(value.Uint(), 10)
+	}
+	if outOfRange {
+		problems.add(field, fmt.Sprintf("%s is out of range, expected %d to %d", formatted, bounds.Min, bounds.Max))
+	}
+}
+
+// isEscapingPath reports whether a cleaned relative path leaves its root
+func isEscapingPath(cleanPath string) bool {
+	return cleanPath == ".." || strings.HasPrefix(cleanPath, "../")
+}
+
+// isJsonArray reports whether data holds a JSON array, ignoring leading whitespace
+func isJsonArray(data string) bool {
+	return strings.HasPrefix(strings.TrimSpace(data), "[")
+}
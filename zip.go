@@ -2,105 +2,197 @@ package main
 
 import (
 	"archive/zip"
-	"crypto/tls"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
-	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-func downloadAndExtractZip(url string, distPath string) {
-	// Get the file form http
-	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-	resp, err := http.Get(url)
-	if err != nil {
-		panic("Failed to download server files from " + url + ", error: " + err.Error())
-	}
-	defer resp.Body.Close()
-
-	// Create a temporary file to store the downloaded ZIP
-	tmpFile, err := os.CreateTemp("", "zip-*.zip")
-	if err != nil {
-		panic("Failed to create temporary file: " + err.Error())
-	}
-	defer os.Remove(tmpFile.Name())
-
-	// Copy the downloaded content to the temporary file
-	_, err = io.Copy(tmpFile, resp.Body)
-	if err != nil {
-		panic("Failed to write server zip fil from " + url + " to " + tmpFile.Name() + ", error: " + err.Error())
-	}
-
-	slog.Info("Extracting archive " + tmpFile.Name() + " to " + distPath)
-
+func extractZipArchive(archivePath string, distPath string, options archiveOptions, filter fileFilter) {
 	// Open the ZIP file for extraction
-	reader, err := zip.OpenReader(tmpFile.Name())
+	reader, err := zip.OpenReader(archivePath)
 	if err != nil {
 		panic("Failed to open zip: " + err.Error())
 	}
 	defer reader.Close()
 
-	zipMembers := reader.File
-	// Check if all files share a common top-level directory
-	var topLevelDir string
-	foundTopLevelDir := false
+	members := make([]archiveMember, len(reader.File))
+	for i, f := range reader.File {
+		members[i] = archiveMember{Name: f.Name, IsDir: f.FileInfo().IsDir()}
+	}
+	mapName := newArchiveNameMapper(members, options)
+
+	var zipMembers []*zip.File
 	for _, f := range reader.File {
-		dir := strings.Split(f.Name, "/")[0]
-
-		if !foundTopLevelDir {
-			topLevelDir = dir
-			foundTopLevelDir = true
-		} else if dir != topLevelDir {
-			slog.Info("Files have different top-level directories, extracting as normal")
-			extractZipFile(zipMembers, distPath)
-			return
+		f.Name = mapName(f.Name)
+		if f.Name != "." {
+			zipMembers = append(zipMembers, f)
 		}
 	}
+	extractZipFile(zipMembers, distPath, filter)
+}
 
-	slog.Warn("All files share a common top-level directory, extracting while omitting the top level directory")
-	for i, f := range reader.File {
-		zipMembers[i].Name = strings.Join(strings.Split(f.Name, "/")[1:], "/")
-	}
-	extractZipFile(zipMembers, distPath)
+// ZipWorkers is how many zip members are extracted at the same time, every worker holds at most two open files
+var ZipWorkers = min(runtime.NumCPU(), 8)
+
+// zipJob is a zip member and the path it is extracted to
+type zipJob struct {
+	file     *zip.File
+	filePath string
 }
 
-func extractZipFile(zipMembers []*zip.File, path string) {
+// extractZipFile extracts the members in three steps: the directories are created up front, the files are written by a
+// bounded pool of workers that close every file as soon as it is written, and the directory times are set last since
+// writing files into a directory changes its modification time
+func extractZipFile(zipMembers []*zip.File, path string, filter fileFilter) {
+	filtered := 0
+	var dirJobs, fileJobs, linkJobs []zipJob
+	dirs := map[string]bool{}
+	fileIndex := map[string]int{}
 	for _, f := range zipMembers {
-		filePath := filepath.Join(path, f.Name)
-		slog.Debug("Extracting file from: " + f.Name + " to: " + filePath)
+		if cleanArchiveMemberName(f.Name) == "." {
+			continue
+		}
+		if !filter.allows(f.Name) {
+			slog.Debug("Filtered out zip member " + f.Name)
+			filtered++
+			continue
+		}
+		filePath, err := resolveArchiveMemberPath(path, f.Name)
+		if err != nil {
+			panic("Refusing to extract zip member: " + err.Error())
+		}
 
 		// Create an empty dir in the destination if the zip file member is an empty dir
 		if f.FileInfo().IsDir() {
-			err := os.MkdirAll(filePath, os.ModePerm)
-			if err != nil {
-				panic("Failed to create directory: " + err.Error())
-			}
+			dirs[filePath] = true
+			dirJobs = append(dirJobs, zipJob{file: f, filePath: filePath})
 			continue
 		}
+		dirs[filepath.Dir(filePath)] = true
+		// Symlinks are created after the files, so no file is ever written through one
+		if f.Mode()&os.ModeSymlink != 0 {
+			linkJobs = append(linkJobs, zipJob{file: f, filePath: filePath})
+			continue
+		}
+		// A member that shows up twice is written once with its last content, like the sequential extraction did
+		if i, ok := fileIndex[filePath]; ok {
+			fileJobs[i].file = f
+			continue
+		}
+		fileIndex[filePath] = len(fileJobs)
+		fileJobs = append(fileJobs, zipJob{file: f, filePath: filePath})
+	}
 
-		// Create the dir for destination file
-		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+	for _, dir := range sortedKeys(dirs) {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
 			panic("Failed to create directory: " + err.Error())
 		}
-		// Create the destination file
-		dstFile, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-		if err != nil {
-			panic("Failed to open destination file: " + filePath + ", error: " + err.Error())
-		}
-		defer dstFile.Close()
+	}
 
-		// Open the zip member file
-		fileInArchive, err := f.Open()
-		if err != nil {
-			panic("Failed to open soure file: " + filePath + ", error: " + err.Error())
+	jobs := make(chan zipJob)
+	var (
+		wg       sync.WaitGroup
+		failOnce sync.Once
+		failed   atomic.Bool
+		failure  error
+	)
+	for range max(ZipWorkers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if failed.Load() {
+					continue
+				}
+				if err := extractZipMember(job.file, job.filePath); err != nil {
+					failOnce.Do(func() {
+						failure = err
+						failed.Store(true)
+					})
+				}
+			}
+		}()
+	}
+	for _, job := range fileJobs {
+		jobs <- job
+	}
+	close(jobs)
+	wg.Wait()
+	if failure != nil {
+		panic(failure.Error())
+	}
+	for _, job := range linkJobs {
+		extractSymlink(path, job.filePath, readZipSymlinkTarget(job.file))
+	}
+
+	for _, job := range dirJobs {
+		if job.file.Modified.IsZero() {
+			continue
+		}
+		if err := os.Chtimes(job.filePath, time.Time{}, job.file.Modified); err != nil {
+			panic("Failed to set modification time of directory: " + job.filePath + ", error: " + err.Error())
 		}
-		defer fileInArchive.Close()
+	}
+	slog.Info(fmt.Sprintf("Extracted %d files and %d directories", len(fileJobs), len(dirs)))
+	if filtered > 0 {
+		slog.Info(fmt.Sprintf("Filtered out %d zip members", filtered))
+	}
+}
+
+// readZipSymlinkTarget returns the link target, zip archives store it as the content of the symlink member
+func readZipSymlinkTarget(f *zip.File) string {
+	reader, err := f.Open()
+	if err != nil {
+		panic("Failed to open soure file: " + f.Name + ", error: " + err.Error())
+	}
+	defer reader.Close()
+	target, err := io.ReadAll(io.LimitReader(reader, 4096))
+	if err != nil {
+		panic("Failed to read symlink target of: " + f.Name + ", error: " + err.Error())
+	}
+	return string(target)
+}
+
+// extractZipMember writes a single file member and keeps its modification time, both files are closed before it
+// returns
+func extractZipMember(f *zip.File, filePath string) error {
+	slog.Debug("Extracting file from: " + f.Name + " to: " + filePath)
+	if err := removeExistingSymlink(filePath); err != nil {
+		return errors.New("Failed to replace existing symlink: " + filePath + ", error: " + err.Error())
+	}
+
+	// Open the zip member file
+	fileInArchive, err := f.Open()
+	if err != nil {
+		return errors.New("Failed to open soure file: " + filePath + ", error: " + err.Error())
+	}
+	defer fileInArchive.Close()
+
+	// Create the destination file
+	dstFile, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
+	if err != nil {
+		return errors.New("Failed to open destination file: " + filePath + ", error: " + err.Error())
+	}
+	// Copy contents from the file in zip to the destination file
+	if _, err := io.Copy(dstFile, fileInArchive); err != nil {
+		dstFile.Close()
+		return errors.New("Failed to write contents to destination file: " + filePath + ", error: " + err.Error())
+	}
+	if err := dstFile.Close(); err != nil {
+		return errors.New("Failed to write contents to destination file: " + filePath + ", error: " + err.Error())
+	}
 
-		// Copy contents from the file in zip to the destination file
-		if _, err := io.Copy(dstFile, fileInArchive); err != nil {
-			panic("Failed to write contents to destination file: " + filePath + ", error: " + err.Error())
+	if !f.Modified.IsZero() {
+		if err := os.Chtimes(filePath, time.Time{}, f.Modified); err != nil {
+			return errors.New("Failed to set modification time of file: " + filePath + ", error: " + err.Error())
 		}
 	}
+	return nil
 }
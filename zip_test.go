@@ -0,0 +1,170 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestZipWithTimes writes members with their modification times, directories end in a slash
+func writeTestZipWithTimes(tb testing.TB, members []testArchiveMember, modified time.Time) string {
+	archivePath := path.Join(tb.TempDir(), "archive.zip")
+	file, err := os.Create(archivePath)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer file.Close()
+
+	zipWriter := zip.NewWriter(file)
+	for _, member := range members {
+		header := &zip.FileHeader{Name: member.Name, Method: zip.Deflate, Modified: modified}
+		if strings.HasSuffix(member.Name, "/") {
+			header.SetMode(os.ModeDir | 0755)
+		} else {
+			header.SetMode(0644)
+		}
+		writer, err := zipWriter.CreateHeader(header)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		if _, err := writer.Write([]byte(member.Content)); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	return archivePath
+}
+
+func TestExtractZipArchiveKeepsModificationTimes(t *testing.T) {
+	modified := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	archivePath := writeTestZipWithTimes(t, []testArchiveMember{
+		{Name: "config/"},
+		{Name: "config/ducky.toml", Content: "ducks = 1"},
+		{Name: "mods/goose.jar", Content: "honk"},
+		{Name: "config/ducky.toml", Content: "ducks = 2"},
+	}, modified)
+	serverDir := t.TempDir()
+
+	extractArchive(archivePath, serverDir, archiveOptions{}, fileFilter{})
+
+	expectFileContent(t, path.Join(serverDir, "config/ducky.toml"), "ducks = 2")
+	expectFileContent(t, path.Join(serverDir, "mods/goose.jar"), "honk")
+	for _, name := range []string{"config", "config/ducky.toml", "mods/goose.jar"} {
+		info, err := os.Stat(path.Join(serverDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(modified) {
+			t.Fatalf("Expected %s to be modified at %v but got %v", name, modified, info.ModTime())
+		}
+	}
+}
+
+func TestExtractZipArchiveReportsWorkerFailure(t *testing.T) {
+	archivePath := writeTestZipWithTimes(t, []testArchiveMember{{Name: "mods/goose.jar", Content: "honk"}}, time.Now())
+	serverDir := t.TempDir()
+	// A directory in the way of a file makes its worker fail
+	if err := os.MkdirAll(path.Join(serverDir, "mods/goose.jar/ducky"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	expectPanic(t, func() {
+		extractArchive(archivePath, serverDir, archiveOptions{}, fileFilter{})
+	})
+}
+
+// extractZipFileSequential is the extraction before the worker pool, every member is extracted in turn and all
+// files stay open until the last one is written. It is kept to compare the throughput
+func extractZipFileSequential(tb testing.TB, zipMembers []*zip.File, distPath string) {
+	var openFiles []io.Closer
+	defer func() {
+		for _, file := range openFiles {
+			file.Close()
+		}
+	}()
+	for _, f := range zipMembers {
+		filePath := filepath.Join(distPath, f.Name)
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(filePath, os.ModePerm); err != nil {
+				tb.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+			tb.Fatal(err)
+		}
+		dstFile, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
+		if err != nil {
+			tb.Fatal(err)
+		}
+		openFiles = append(openFiles, dstFile)
+		fileInArchive, err := f.Open()
+		if err != nil {
+			tb.Fatal(err)
+		}
+		openFiles = append(openFiles, fileInArchive)
+		if _, err := io.Copy(dstFile, fileInArchive); err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+// writeBenchmarkZip writes a pack of 10k small files spread over 100 folders
+func writeBenchmarkZip(b *testing.B) string {
+	members := make([]testArchiveMember, 0, 10000)
+	for i := range 10000 {
+		members = append(members, testArchiveMember{
+			Name:    fmt.Sprintf("config/mod%03d/file%05d.json", i%100, i),
+			Content: fmt.Sprintf(`{"ducks": %d, "padding": "%0512d"}`, i, i),
+		})
+	}
+	return writeTestZipWithTimes(b, members, time.Now())
+}
+
+func benchmarkZipExtraction(b *testing.B, extract func(zipMembers []*zip.File, distPath string)) {
+	archivePath := writeBenchmarkZip(b)
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer reader.Close()
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.SetBytes(info.Size())
+	b.ResetTimer()
+	for range b.N {
+		b.StopTimer()
+		distPath := b.TempDir()
+		b.StartTimer()
+		extract(reader.File, distPath)
+	}
+}
+
+func BenchmarkExtractZipFile(b *testing.B) {
+	defer func(workers int) { ZipWorkers = workers }(ZipWorkers)
+	for _, workers := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			ZipWorkers = workers
+			benchmarkZipExtraction(b, func(zipMembers []*zip.File, distPath string) {
+				extractZipFile(zipMembers, distPath, fileFilter{})
+			})
+		})
+	}
+}
+
+func BenchmarkExtractZipFileSequential(b *testing.B) {
+	benchmarkZipExtraction(b, func(zipMembers []*zip.File, distPath string) {
+		extractZipFileSequential(b, zipMembers, distPath)
+	})
+}